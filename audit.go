@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "time"
+)
+
+//------------------------------------------------------------
+// Reporting times on disk inconsistent with history
+//------------------------------------------------------------
+
+// Leeway for clocks and time zones before a time is taken for wrong.
+const auditSlack = 24 * time.Hour
+
+// File whose time on disk history can't explain.
+type auditFinding struct {
+    Severity  string    `json:"severity"` // error or warning
+    Kind      string    `json:"kind"`     // future or before-added
+    Path      string    `json:"path"`
+    Mtime     time.Time `json:"mtime"`     // Time on disk
+    Reference time.Time `json:"reference"` // Time it's checked against
+}
+
+// Reports files whose time on disk is wildly off their history, changing
+// nothing: in the future is an error, before the commit adding the file
+// a warning. Either way the file was likely corrupted or badly extracted.
+// Files applyPlan would skip aren't checked.
+func auditPlan(root string, plan []planEntry, modes map[string]string, opts Options) (findings int) {
+    added, err := gitAddedTimes(nil, planPathspecs(opts))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error finding times files were added: %v\n", err)
+        exit(1)
+    }
+
+    fpaths := localPaths(root, plan, opts.PathMapper)
+    states := statPlan(fpaths, opts.StatJobs, opts.NoDereference)
+    now := time.Now()
+
+    checked := 0
+    for i, e := range plan {
+        st := states[i]
+        if fpaths[i] == "" || st.Err != nil || !sameKind(modes[e.Path], st.Info.Mode()) {
+            continue
+        }
+        if modes[e.Path] == "160000" && !opts.Gitlinks {
+            continue
+        }
+        // Followed, a symlink has the time of its target, checked by itself
+        if st.Info.Mode()&os.ModeSymlink != 0 && !opts.NoDereference {
+            continue
+        }
+
+        checked++
+        f := auditFinding{Path: e.Path, Mtime: st.Mtime}
+        switch {
+        case st.Mtime.After(now.Add(auditSlack)):
+            f.Severity, f.Kind, f.Reference = "error", "future", now
+        case !added[e.Path].IsZero() && st.Mtime.Before(added[e.Path].Add(-auditSlack)):
+            f.Severity, f.Kind, f.Reference = "warning", "before-added", added[e.Path]
+        default:
+            continue
+        }
+        findings++
+        printFinding(f, opts)
+    }
+    fmt.Fprintf(os.Stderr, "gitime: %d of %d files have anomalous mtime\n", findings, checked)
+    return
+}
+
+// Prints finding of --audit, one JSON object per line with json format.
+func printFinding(f auditFinding, opts Options) {
+    if opts.Format == "json" {
+        data, _ := json.Marshal(f)
+        fmt.Println(string(data))
+        return
+    }
+
+    reason := "in the future"
+    if f.Kind == "before-added" {
+        reason = "before the file was added " + formatTime(f.Reference, opts.TimeFormat)
+    }
+    fmt.Printf("%v %v: %v: mtime %v %v\n", f.Severity, f.Kind, f.Path, formatTime(f.Mtime, opts.TimeFormat), reason)
+}
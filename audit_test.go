@@ -0,0 +1,58 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Times far in the future or far before the file was added are found,
+// nothing is changed, and findings make the exit status 1.
+func TestAudit(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"future": "1", "ancient": "1", "fine": "1", "recent": "1"})
+    future := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+    ancient := mustTime(t, "1990-01-01T00:00:00Z")
+    r.setMtime("future", future)
+    r.setMtime("ancient", ancient)
+    r.setMtime("fine", mustTime(t, "2020-01-01T00:00:00Z"))
+    r.setMtime("recent", mustTime(t, "2019-12-31T12:00:00Z"))
+
+    run := r.run("--audit", "--format", "json")
+    if run.Code != 1 {
+        t.Errorf("exit status %d, want 1: %v", run.Code, run.Stderr)
+    }
+    got := map[string]auditFinding{}
+    for _, line := range strings.Split(strings.TrimSpace(run.Stdout), "\n") {
+        var f auditFinding
+        if err := json.Unmarshal([]byte(line), &f); err != nil {
+            t.Fatalf("bad finding %q: %v", line, err)
+        }
+        got[f.Path] = f
+    }
+
+    want := map[string]auditFinding{
+        "future":  {Severity: "error", Kind: "future", Path: "future", Mtime: future},
+        "ancient": {Severity: "warning", Kind: "before-added", Path: "ancient", Mtime: ancient, Reference: mustTime(t, "2020-01-01T00:00:00Z")},
+    }
+    if len(got) != len(want) {
+        t.Errorf("got findings %v, want future and ancient only", got)
+    }
+    for f, w := range want {
+        g := got[f]
+        if g.Severity != w.Severity || g.Kind != w.Kind || !g.Mtime.Equal(w.Mtime) || (!w.Reference.IsZero() && !g.Reference.Equal(w.Reference)) {
+            t.Errorf("%v: got %+v, want %+v", f, g, w)
+        }
+    }
+    if !r.mtime("future").Equal(future) || !r.mtime("ancient").Equal(ancient) {
+        t.Errorf("audit changed times")
+    }
+
+    // Nothing to find
+    r.setMtime("future", mustTime(t, "2020-01-01T00:00:00Z"))
+    r.setMtime("ancient", mustTime(t, "2020-01-01T00:00:00Z"))
+    if run := r.run("--audit"); run.Code != 0 || run.Stdout != "" {
+        t.Errorf("clean tree: exit status %d: %v", run.Code, run.Stdout)
+    }
+}
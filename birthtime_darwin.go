@@ -0,0 +1,47 @@
+//go:build darwin
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "time"
+    "unsafe"
+)
+
+// File creation times can be set here.
+const birthtimeSupported = true
+
+// Attribute list of setattrlist, see attrlist in sys/attr.h.
+type attrList struct {
+    bitmapCount uint16
+    reserved    uint16
+    commonAttr  uint32
+    volAttr     uint32
+    dirAttr     uint32
+    fileAttr    uint32
+    forkAttr    uint32
+}
+
+const (
+    attrBitMapCount = 5
+    attrCmnCrtime   = 0x00000200
+)
+
+// Sets creation time of file, following symlinks like Chtimes.
+func setBirthtime(fpath string, t time.Time) error {
+    p, err := syscall.BytePtrFromString(fpath)
+    if err != nil {
+        return err
+    }
+    attrs := attrList{bitmapCount: attrBitMapCount, commonAttr: attrCmnCrtime}
+    ts := syscall.NsecToTimespec(t.UnixNano())
+
+    _, _, errno := syscall.Syscall6(syscall.SYS_SETATTRLIST,
+        uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&attrs)),
+        uintptr(unsafe.Pointer(&ts)), unsafe.Sizeof(ts), 0, 0)
+    if errno != 0 {
+        return &os.PathError{Op: "setattrlist", Path: fpath, Err: errno}
+    }
+    return nil
+}
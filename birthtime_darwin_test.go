@@ -0,0 +1,33 @@
+//go:build darwin
+
+package main
+
+import (
+    "syscall"
+    "testing"
+    "time"
+)
+
+// Creation time is set to the commit first adding the file with first,
+// to the last one changing it with last, modification time to the last.
+func TestSetBirthtime(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"a": "2"})
+
+    for policy, want := range map[string]string{"first": "2019-01-01T00:00:00Z", "last": "2021-01-01T00:00:00Z"} {
+        if run := r.run("--set-birthtime", policy); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", policy, run.Code, run.Stderr)
+        }
+        var st syscall.Stat_t
+        if err := syscall.Stat(r.path("a"), &st); err != nil {
+            t.Fatal(err)
+        }
+        if got := time.Unix(st.Birthtimespec.Unix()); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: creation time %v, want %v", policy, got, want)
+        }
+        if got := r.mtime("a"); !got.Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+            t.Errorf("%v: got %v", policy, got)
+        }
+    }
+}
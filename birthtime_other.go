@@ -0,0 +1,16 @@
+//go:build !darwin
+
+package main
+
+import (
+    "errors"
+    "time"
+)
+
+// File creation times can't be set here.
+const birthtimeSupported = false
+
+// Sets creation time of file, which isn't supported here.
+func setBirthtime(fpath string, t time.Time) error {
+    return errors.New("setting creation time is only supported on macOS")
+}
@@ -0,0 +1,24 @@
+//go:build !darwin
+
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// Creation time can't be set here, asking for it warns and retimes as
+// without.
+func TestSetBirthtime(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"a": "2"})
+
+    run := r.run("--set-birthtime", "first")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "WARNING --set-birthtime is only supported on macOS, ignored") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if got := r.mtime("a"); !got.Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+        t.Errorf("got %v", got)
+    }
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "unicode"
+)
+
+//------------------------------------------------------------
+// Paths differing only in case
+//------------------------------------------------------------
+
+// Tells if two paths under root name one file on disk, as on a case
+// insensitive file system. Folding may be set per directory, like ext4
+// casefold on Linux, so it's looked up for the very paths. Nothing is
+// written, so dry runs can tell too.
+func sameOnDisk(root, a, b string) bool {
+    fa, err := os.Lstat(filepath.Join(root, filepath.FromSlash(a)))
+    if err != nil {
+        return false
+    }
+    fb, err := os.Lstat(filepath.Join(root, filepath.FromSlash(b)))
+    return err == nil && os.SameFile(fa, fb)
+}
+
+// Tells if file system ignores case of names in directory, by creating
+// a temporary file and looking it up by its name in another case.
+func probeCaseFolding(dir string) (folds bool, err error) {
+    f, err := os.CreateTemp(dir, ".gitime-Case-*")
+    if err != nil {
+        return
+    }
+    fpath := f.Name()
+    f.Close()
+    defer os.Remove(fpath)
+
+    fi, err := os.Lstat(fpath)
+    if err != nil {
+        return
+    }
+    other, err := os.Lstat(filepath.Join(dir, flipCase(filepath.Base(fpath))))
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return
+    }
+    return os.SameFile(fi, other), nil
+}
+
+// Swaps upper and lower case letters of name.
+func flipCase(name string) string {
+    return strings.Map(func(r rune) rune {
+        if l := unicode.ToLower(r); l != r {
+            return l
+        }
+        return unicode.ToUpper(r)
+    }, name)
+}
+
+// Merges files of the plan whose paths differ only in case and are one
+// file on disk under root, as on a case insensitive file system, like
+// Foo.txt renamed to foo.txt. The merged file takes the newest time of
+// them and the path tracked at HEAD, so the older name can't set it
+// back. Returns groups of paths merged.
+func mergeCaseCollisions(root string, plan []planEntry, modes map[string]string) (merged []planEntry, collisions [][]string) {
+    // Files kept by lower case path, one for each file on disk, and
+    // paths merged into each
+    index := map[string][]int{}
+    groups := map[int][]string{}
+    for _, e := range plan {
+        key := strings.ToLower(e.Path)
+        i := -1
+        for _, j := range index[key] {
+            if sameOnDisk(root, merged[j].Path, e.Path) {
+                i = j
+                break
+            }
+        }
+        if i == -1 {
+            // Told apart here, or first of the names
+            index[key] = append(index[key], len(merged))
+            groups[len(merged)] = []string{e.Path}
+            merged = append(merged, e)
+            continue
+        }
+        groups[i] = append(groups[i], e.Path)
+
+        kept := &merged[i]
+        if _, tracked := modes[e.Path]; tracked {
+            kept.Path = e.Path
+        }
+        if e.Mtime.After(kept.Mtime) {
+            kept.Mtime, kept.Commit, kept.Unresolved = e.Mtime, e.Commit, e.Unresolved
+        }
+    }
+
+    for i := range merged {
+        if group := groups[i]; len(group) > 1 {
+            collisions = append(collisions, group)
+        }
+    }
+    return
+}
+
+// Prints groups of paths merged for differing only in case.
+func printCaseCollisions(collisions [][]string) {
+    for _, group := range collisions {
+        fmt.Fprintf(os.Stderr, "WARNING paths differ only in case, one file here, taking newest time: %v\n", strings.Join(group, ", "))
+    }
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// Names of one file on disk are merged to the newest time, whichever
+// name of the group they match. Hard links stand in for names a case
+// insensitive file system folds to one file.
+func TestMergeCaseCollisions(t *testing.T) {
+    day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+    tests := []struct {
+        name       string
+        links      [][]string // Names of each file on disk
+        plan       []planEntry
+        tracked    []string
+        want       []planEntry
+        collisions [][]string
+    }{
+        {
+            name:  "separate files",
+            links: [][]string{{"Foo"}, {"foo"}},
+            plan:  []planEntry{{Path: "Foo", Mtime: day(1)}, {Path: "foo", Mtime: day(2)}},
+            want:  []planEntry{{Path: "Foo", Mtime: day(1)}, {Path: "foo", Mtime: day(2)}},
+        },
+        {
+            name:       "one file",
+            links:      [][]string{{"Foo", "foo"}},
+            plan:       []planEntry{{Path: "Foo", Mtime: day(1)}, {Path: "foo", Mtime: day(2)}},
+            tracked:    []string{"Foo"},
+            want:       []planEntry{{Path: "Foo", Mtime: day(2)}},
+            collisions: [][]string{{"Foo", "foo"}},
+        },
+        {
+            name:       "third name matching the second",
+            links:      [][]string{{"Foo"}, {"foo", "FOO"}},
+            plan:       []planEntry{{Path: "FOO", Mtime: day(3)}, {Path: "Foo", Mtime: day(1)}, {Path: "foo", Mtime: day(2)}},
+            tracked:    []string{"foo"},
+            want:       []planEntry{{Path: "foo", Mtime: day(3)}, {Path: "Foo", Mtime: day(1)}},
+            collisions: [][]string{{"FOO", "foo"}},
+        },
+        {
+            name:       "three names",
+            links:      [][]string{{"a/B", "a/b", "A/b"}},
+            plan:       []planEntry{{Path: "A/b", Mtime: day(1)}, {Path: "a/B", Mtime: day(3)}, {Path: "a/b", Mtime: day(2)}},
+            tracked:    []string{"a/b"},
+            want:       []planEntry{{Path: "a/b", Mtime: day(3)}},
+            collisions: [][]string{{"A/b", "a/B", "a/b"}},
+        },
+    }
+    for _, tt := range tests {
+        root := t.TempDir()
+        for _, names := range tt.links {
+            for i, name := range names {
+                fpath := filepath.Join(root, filepath.FromSlash(name))
+                if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+                    t.Fatal(err)
+                }
+                var err error
+                if i == 0 {
+                    err = os.WriteFile(fpath, nil, 0644)
+                } else {
+                    err = os.Link(filepath.Join(root, filepath.FromSlash(names[0])), fpath)
+                }
+                if err != nil {
+                    t.Skipf("%v: can't make names: %v", tt.name, err)
+                }
+            }
+        }
+        modes := map[string]string{}
+        for _, f := range tt.tracked {
+            modes[f] = "100644"
+        }
+
+        merged, collisions := mergeCaseCollisions(root, tt.plan, modes)
+        if !reflect.DeepEqual(merged, tt.want) {
+            t.Errorf("%v: merged %v, want %v", tt.name, merged, tt.want)
+        }
+        if !reflect.DeepEqual(collisions, tt.collisions) {
+            t.Errorf("%v: collisions %v, want %v", tt.name, collisions, tt.collisions)
+        }
+    }
+}
+
+// On a case insensitive file system a file renamed from Foo.txt to
+// foo.txt keeps the newest time of both names.
+func TestCaseCollisionOnDisk(t *testing.T) {
+    r := newTestRepo(t)
+    if folds, err := probeCaseFolding(r.Dir); err != nil || !folds {
+        t.Skip("file system of temporary directories is case sensitive")
+    }
+    r.git("config", "core.ignorecase", "true")
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"Foo.txt": "1"})
+    r.git("mv", "Foo.txt", "foo.txt")
+    r.gitAt("2019-01-01T00:00:00Z", "commit", "-q", "-m", "rename")
+
+    run := r.run("--verbose")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if got := r.mtime("foo.txt"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("got %v, want newest time of both names", got)
+    }
+}
+
+func TestFlipCase(t *testing.T) {
+    tests := map[string]string{
+        ".gitime-Case-123": ".GITIME-cASE-123",
+        "straße":           "STRAßE",
+        "ÄbC":              "äBc",
+        "123":              "123",
+    }
+    for name, want := range tests {
+        if got := flipCase(name); got != want {
+            t.Errorf("flipCase(%q) = %q, want %q", name, got, want)
+        }
+    }
+}
+
+// Probe agrees with looking up a file by a name in another case, and
+// leaves nothing behind. Temporary directories are usually case
+// sensitive on Linux, folding ones are checked where they are.
+func TestProbeCaseFolding(t *testing.T) {
+    dir := t.TempDir()
+    folds, err := probeCaseFolding(dir)
+    if err != nil {
+        t.Skipf("can't probe temporary directory: %v", err)
+    }
+
+    fpath := filepath.Join(dir, "Probe.txt")
+    if err := os.WriteFile(fpath, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    fi, _ := os.Lstat(fpath)
+    other, err := os.Lstat(filepath.Join(dir, "pROBE.TXT"))
+    if want := err == nil && os.SameFile(fi, other); folds != want {
+        t.Errorf("probe says folding %v, lookup says %v", folds, want)
+    }
+    if !sameOnDisk(dir, "Probe.txt", "Probe.txt") || sameOnDisk(dir, "Probe.txt", "missing") {
+        t.Errorf("sameOnDisk wrong for one name or a missing one")
+    }
+    if sameOnDisk(dir, "Probe.txt", "pROBE.TXT") != folds {
+        t.Errorf("sameOnDisk disagrees with probe")
+    }
+
+    entries, err := os.ReadDir(dir)
+    if err != nil || len(entries) != 1 {
+        t.Errorf("probe left files behind: %v", entries)
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Asking before applying
+//------------------------------------------------------------
+
+// Files of the plan shown before asking.
+const confirmSample = 5
+
+// Shows summary and sample of the plan and asks whether to apply it,
+// on the terminal only. Without a terminal to ask on it refuses, so a
+// --confirm in CI or a pipe never applies unasked, unless told yes.
+func confirmApply(plan []planEntry, opts Options) bool {
+    if opts.Yes {
+        return true
+    }
+    if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+        fmt.Fprintln(os.Stderr, "Error --confirm has no terminal to ask on, stdin isn't one; add --yes to apply without asking")
+        return false
+    }
+    return askApply(plan, opts, os.Stdin)
+}
+
+// Prints summary and sample of the plan to stderr and reads the answer.
+// Only y or yes applies, end of input is no.
+func askApply(plan []planEntry, opts Options, in io.Reader) bool {
+    printPlanSummary(plan)
+    for i, e := range plan {
+        if i == confirmSample {
+            fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(plan)-i)
+            break
+        }
+        fmt.Fprintf(os.Stderr, "  %v : %v\n", formatTime(e.Mtime, opts.TimeFormat), e.Path)
+    }
+    fmt.Fprintf(os.Stderr, "Apply to %d files? [y/N] ", len(plan))
+
+    answer, err := bufio.NewReader(in).ReadString('\n')
+    if err != nil {
+        fmt.Fprintln(os.Stderr)
+    }
+    switch strings.ToLower(strings.TrimSpace(answer)) {
+    case "y", "yes":
+        return true
+    default:
+        return false
+    }
+}
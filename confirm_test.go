@@ -0,0 +1,51 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// Only y or yes applies.
+func TestAskApply(t *testing.T) {
+    plan := []planEntry{{Path: "a", Mtime: time.Unix(1600000000, 0)}}
+    tests := []struct {
+        answer string
+        want   bool
+    }{
+        {"y\n", true},
+        {"yes\n", true},
+        {" Y \n", true},
+        {"n\n", false},
+        {"no\n", false},
+        {"\n", false},
+        {"yeah\n", false},
+        {"", false},
+        {"y", true},
+    }
+    for _, tt := range tests {
+        if got := askApply(plan, Options{}, strings.NewReader(tt.answer)); got != tt.want {
+            t.Errorf("answer %q: got %v, want %v", tt.answer, got, tt.want)
+        }
+    }
+}
+
+// Without a terminal --confirm refuses unless told yes.
+func TestConfirmRun(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    before := r.mtime("a")
+
+    run := runGitime(t, r.Dir, "y\n", "--confirm")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "no terminal") {
+        t.Errorf("exit status %d, want 1 refusing: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("a").Equal(before) {
+        t.Errorf("applied without a terminal to ask on")
+    }
+
+    run = runGitime(t, r.Dir, "", "--confirm", "--yes")
+    if run.Code != 0 || !r.mtime("a").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("--yes: exit status %d, not applied: %v", run.Code, run.Stderr)
+    }
+}
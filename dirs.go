@@ -0,0 +1,135 @@
+package main
+
+import (
+    "fmt"
+    "io/fs"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+//------------------------------------------------------------
+// Directory times
+//------------------------------------------------------------
+
+// Gives each directory holding tracked files the newest time of files
+// under it, the top of the tree included.
+// Files deleted from HEAD don't count, they aren't in the directory.
+func dirTimes(plan []planEntry, modes map[string]string) (dirs map[string]planEntry) {
+    dirs = map[string]planEntry{}
+    for _, e := range plan {
+        if _, tracked := modes[e.Path]; !tracked {
+            continue
+        }
+        for d := path.Dir(e.Path); ; d = path.Dir(d) {
+            if cur, ok := dirs[d]; !ok || e.Mtime.After(cur.Mtime) {
+                dirs[d] = planEntry{Path: d, Mtime: e.Mtime, Commit: e.Commit}
+            }
+            if d == "." {
+                break
+            }
+        }
+    }
+    return
+}
+
+// Finds directories on disk no tracked file is under, holding only
+// untracked or ignored files, and gives them time by policy: now, or
+// that of the nearest parent directory. Submodules and .git aren't
+// entered.
+func emptyDirTimes(root string, dirs map[string]planEntry, modes map[string]string, policy string, now time.Time) (empty map[string]planEntry, err error) {
+    empty = map[string]planEntry{}
+    err = filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if !d.IsDir() {
+            return nil
+        }
+        rel, err := filepath.Rel(root, fpath)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if d.Name() == ".git" || modes[rel] == "160000" {
+            return filepath.SkipDir
+        }
+        if _, ok := dirs[rel]; ok {
+            return nil
+        }
+
+        // Parents are walked first, so have their time already
+        e := planEntry{Path: rel, Mtime: now}
+        if policy == "parent" {
+            parent, ok := dirs[path.Dir(rel)]
+            if !ok {
+                parent, ok = empty[path.Dir(rel)]
+            }
+            if !ok {
+                return nil
+            }
+            e.Mtime, e.Commit = parent.Mtime, parent.Commit
+        }
+        empty[rel] = e
+        return nil
+    })
+    return
+}
+
+// Sets times of directories under root after their files, see dirTimes.
+// Directories without tracked files are left alone by skip policy, see
+// emptyDirTimes for others.
+// Directory paths are git paths, the path mapper is for files only.
+func applyDirs(root string, plan []planEntry, modes map[string]string, opts Options, now time.Time, stats *runStats) (err error) {
+    dirs := dirTimes(plan, modes)
+    if opts.EmptyDirTime != "skip" {
+        empty, err := emptyDirTimes(root, dirs, modes, opts.EmptyDirTime, now)
+        if err != nil {
+            return err
+        }
+        for d, e := range empty {
+            dirs[d] = e
+        }
+    }
+
+    retimeDirs(root, dirs, opts, stats)
+    return
+}
+
+// Sets times of directories under root, by path relative to it, in
+// order of path. Paths not a directory on disk are left alone.
+func retimeDirs(root string, dirs map[string]planEntry, opts Options, stats *runStats) {
+    names := make([]string, 0, len(dirs))
+    for d := range dirs {
+        names = append(names, d)
+    }
+    sort.Strings(names)
+
+    for _, d := range names {
+        e := dirs[d]
+        fpath := path.Join(root, d)
+        fi, err := os.Stat(fpath)
+        if err != nil || !fi.IsDir() {
+            continue
+        }
+
+        // Shown with trailing slash to tell from files
+        shown := e
+        shown.Path = d + "/"
+        printEntry(shown, opts)
+        if opts.DryRun {
+            continue
+        }
+
+        if err := setMtime(fpath, e.Mtime, opts); err != nil {
+            if stats.showError(opts.ErrorLimit) {
+                fmt.Fprintf(os.Stderr, "Error changing directory mtime: %v\n", err)
+            }
+            stats.addError(shown.Path, err)
+            continue
+        }
+        stats.Dirs++
+    }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+// Directories without tracked files keep their time with skip policy,
+// get now or the time of their nearest parent with the others.
+func TestEmptyDirTime(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"src/a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"README": "1"})
+    r.write("src/cache/deep/x", "untracked")
+    r.write("empty/x", "untracked")
+    empty := []string{"src/cache", "src/cache/deep", "empty"}
+    old := mustTime(t, "2000-01-01T00:00:00Z")
+
+    tests := []struct {
+        policy string
+        want   map[string]string // Time by directory, "now" for the time of the run
+    }{
+        {"skip", map[string]string{"src/cache": "2000-01-01T00:00:00Z", "src/cache/deep": "2000-01-01T00:00:00Z", "empty": "2000-01-01T00:00:00Z"}},
+        {"now", map[string]string{"src/cache": "now", "src/cache/deep": "now", "empty": "now"}},
+        {"parent", map[string]string{"src/cache": "2020-01-01T00:00:00Z", "src/cache/deep": "2020-01-01T00:00:00Z", "empty": "2021-01-01T00:00:00Z"}},
+    }
+    for _, tt := range tests {
+        for _, d := range empty {
+            r.setMtime(d, old)
+        }
+        start := time.Now().Truncate(time.Second)
+        if run := r.run("--dirs", "--empty-dir-time", tt.policy); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.policy, run.Code, run.Stderr)
+        }
+        if got := r.mtime("src"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: src got %v, want newest of its files", tt.policy, got)
+        }
+        for d, want := range tt.want {
+            got := r.mtime(d)
+            switch {
+            case want == "now" && got.Before(start):
+                t.Errorf("%v: %v got %v, want now", tt.policy, d, got)
+            case want != "now" && !got.Equal(mustTime(t, want)):
+                t.Errorf("%v: %v got %v, want %v", tt.policy, d, got, want)
+            }
+        }
+    }
+}
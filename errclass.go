@@ -0,0 +1,91 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+    "syscall"
+)
+
+//------------------------------------------------------------
+// Grouping failures by cause
+//------------------------------------------------------------
+
+// Classes of errors changing file times, in order reported.
+var errorClasses = []string{"permission-denied", "not-found", "read-only", "transient", "other"}
+
+// Number of example files reported per error class.
+const errorExamples = 3
+
+// Tells class of error changing file time, or of git failing for a
+// file, told by what git printed and its exit status.
+func errorClass(err error) string {
+    var gerr *gitError
+    if errors.As(err, &gerr) {
+        return gitErrorClass(gerr)
+    }
+    switch {
+    case errors.Is(err, syscall.EROFS):
+        return "read-only"
+    case errors.Is(err, os.ErrPermission):
+        return "permission-denied"
+    case errors.Is(err, os.ErrNotExist):
+        return "not-found"
+    case errors.Is(err, syscall.EINTR), errors.Is(err, syscall.EAGAIN),
+        errors.Is(err, syscall.EBUSY), errors.Is(err, syscall.ETIMEDOUT):
+        return "transient"
+    }
+    return "other"
+}
+
+// Tells class of git failure. Git killed may work when tried again, as
+// may one finding the index locked by another git.
+func gitErrorClass(gerr *gitError) string {
+    msg := strings.ToLower(gerr.Stderr)
+    switch {
+    case errors.Is(gerr.Err, exec.ErrNotFound):
+        return "not-found"
+    case strings.Contains(msg, "read-only file system"):
+        return "read-only"
+    case strings.Contains(msg, "permission denied"):
+        return "permission-denied"
+    case strings.Contains(msg, "no such file"), strings.Contains(msg, "does not exist"),
+        strings.Contains(msg, "unknown revision"), strings.Contains(msg, "no such path"):
+        return "not-found"
+    case gerr.ExitCode == -1, strings.Contains(msg, "index.lock"):
+        return "transient"
+    }
+    return "other"
+}
+
+// Records file failed to retime.
+func (stats *runStats) addError(f string, err error) {
+    if stats.Failed == nil {
+        stats.Failed = map[string][]string{}
+    }
+    class := errorClass(err)
+    stats.Failed[class] = append(stats.Failed[class], f)
+    stats.Errors++
+}
+
+// Tells if error of another file is printed, fewer than limit being so
+// far. Limit 0 prints all.
+func (stats *runStats) showError(limit int) bool {
+    return limit == 0 || stats.Errors < limit
+}
+
+// Prints count of failed files of each class with a few of them.
+func printErrorClasses(stats runStats) {
+    for _, class := range errorClasses {
+        files := stats.Failed[class]
+        if len(files) == 0 {
+            continue
+        }
+        fmt.Fprintf(os.Stderr, "  %v: %d\n", class, len(files))
+        for _, f := range files[:min(len(files), errorExamples)] {
+            fmt.Fprintf(os.Stderr, "    %v\n", f)
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "errors"
+    "os"
+    "os/exec"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+// Errors changing times and of git are each put in their class.
+func TestErrorClass(t *testing.T) {
+    pathErr := func(errno syscall.Errno) error {
+        return &os.PathError{Op: "chtimes", Path: "f", Err: errno}
+    }
+    tests := []struct {
+        err  error
+        want string
+    }{
+        {pathErr(syscall.EROFS), "read-only"},
+        {pathErr(syscall.EACCES), "permission-denied"},
+        {pathErr(syscall.EPERM), "permission-denied"},
+        {pathErr(syscall.ENOENT), "not-found"},
+        {pathErr(syscall.EINTR), "transient"},
+        {pathErr(syscall.EBUSY), "transient"},
+        {pathErr(syscall.EIO), "other"},
+        {&gitError{ExitCode: 128, Stderr: "fatal: could not write index: Read-only file system"}, "read-only"},
+        {&gitError{ExitCode: 128, Stderr: "error: cannot open .git/FETCH_HEAD: Permission denied"}, "permission-denied"},
+        {&gitError{ExitCode: 128, Stderr: "fatal: no such path 'f' in HEAD"}, "not-found"},
+        {&gitError{ExitCode: 128, Stderr: "fatal: ambiguous argument 'x': unknown revision or path not in the working tree."}, "not-found"},
+        {&gitError{ExitCode: -1, Err: &exec.Error{Name: "git", Err: exec.ErrNotFound}}, "not-found"},
+        {&gitError{ExitCode: 128, Stderr: "fatal: Unable to create '/r/.git/index.lock': File exists."}, "transient"},
+        {&gitError{ExitCode: -1, Err: errors.New("signal: killed")}, "transient"},
+        {&gitError{ExitCode: 1}, "other"},
+    }
+    for _, tt := range tests {
+        if got := errorClass(tt.err); got != tt.want {
+            t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+        }
+    }
+}
+
+// Summary counts failed files of each class, with examples.
+func TestErrorClassSummary(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1", "d": "1", "e": "1"})
+
+    run := r.runFailing("a=EROFS,b=EACCES,c=EINTR,d=EIO")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "Error changing mtime of 4 files") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, want := range []string{"  read-only: 1\n    a\n", "  permission-denied: 1\n    b\n", "  transient: 1\n    c\n", "  other: 1\n    d\n"} {
+        if !strings.Contains(run.Stderr, want) {
+            t.Errorf("summary lacks %q: %v", want, run.Stderr)
+        }
+    }
+    if strings.Contains(run.Stderr, "not-found") {
+        t.Errorf("summary has class without failures: %v", run.Stderr)
+    }
+}
+
+// Errors past the limit are counted, not printed, all are with no limit.
+func TestErrorLimit(t *testing.T) {
+    r := newTestRepo(t)
+    files := map[string]string{}
+    for i := 0; i < 10; i++ {
+        files[string(rune('a'+i))] = "1"
+    }
+    r.commit("2020-01-01T00:00:00Z", files)
+
+    tests := []struct {
+        limit   string
+        printed int
+        more    string
+    }{
+        {"3", 3, "... and 7 more errors"},
+        {"0", 10, ""},
+    }
+    for _, tt := range tests {
+        run := r.runFailing("*=EIO", "--error-limit", tt.limit)
+        if run.Code != 1 || !strings.Contains(run.Stderr, "Error changing mtime of 10 files") {
+            t.Errorf("limit %v: exit status %d: %v", tt.limit, run.Code, run.Stderr)
+        }
+        if n := strings.Count(run.Stderr, "Error changing file mtime:"); n != tt.printed {
+            t.Errorf("limit %v: printed %d errors, want %d", tt.limit, n, tt.printed)
+        }
+        if got := strings.Contains(run.Stderr, "more errors"); got != (tt.more != "") || !strings.Contains(run.Stderr, tt.more) {
+            t.Errorf("limit %v: want %q: %v", tt.limit, tt.more, run.Stderr)
+        }
+    }
+}
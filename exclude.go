@@ -0,0 +1,121 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Files never to retime
+//------------------------------------------------------------
+
+// Reads exclude patterns, one per line like .gitignore has them.
+// Blank lines and lines starting with # are ignored.
+func loadExcludes(fpath string) (patterns []string, err error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for n := 1; scanner.Scan(); n++ {
+        line := strings.TrimRight(scanner.Text(), " \t\r")
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if err = checkExclude(line); err != nil {
+            return nil, fmt.Errorf("%v:%d: %v", fpath, n, err)
+        }
+        patterns = append(patterns, line)
+    }
+    err = scanner.Err()
+    return
+}
+
+// Tells if exclude pattern is one gitime understands.
+// Negation of .gitignore isn't supported, nor is ** as globs are those
+// of path.Match.
+func checkExclude(pattern string) error {
+    if strings.HasPrefix(pattern, "!") {
+        return fmt.Errorf("negated pattern not supported: %v", pattern)
+    }
+    if strings.Contains(pattern, "**") {
+        return fmt.Errorf("** not supported, * matches within a directory: %v", pattern)
+    }
+    if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+        return fmt.Errorf("bad pattern: %v", pattern)
+    }
+    return nil
+}
+
+// Tells if file matches exclude pattern.
+// As in .gitignore, pattern with a slash other than trailing is anchored
+// at the top of the tree, otherwise it matches a name at any depth.
+// Pattern matching a directory excludes all under it, trailing slash
+// matches directories only.
+func excluded(f, pattern string) bool {
+    dirOnly := strings.HasSuffix(pattern, "/")
+    pattern = strings.TrimSuffix(pattern, "/")
+    anchored := strings.Contains(pattern, "/")
+    pattern = strings.TrimPrefix(pattern, "/")
+
+    parts := strings.Split(f, "/")
+    for i := range parts {
+        // The last part is the file itself, not a directory
+        if dirOnly && i == len(parts)-1 {
+            break
+        }
+
+        name := parts[i]
+        if anchored {
+            name = strings.Join(parts[:i+1], "/")
+        }
+        if ok, _ := path.Match(pattern, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// Turns exclude patterns to git pathspecs of the same files, letting git
+// skip them early.
+func excludePathspecs(patterns []string) (pathspecs []string) {
+    for _, p := range patterns {
+        dirOnly := strings.HasSuffix(p, "/")
+        p = strings.TrimSuffix(p, "/")
+        if strings.Contains(p, "/") {
+            p = strings.TrimPrefix(p, "/")
+        } else {
+            p = "**/" + p
+        }
+
+        if !dirOnly {
+            pathspecs = append(pathspecs, ":(exclude,glob)"+p)
+        }
+        pathspecs = append(pathspecs, ":(exclude,glob)"+p+"/**")
+    }
+    return
+}
+
+// Drops files of the plan matching any exclude pattern.
+func filterExcluded(plan []planEntry, patterns []string) (kept []planEntry, count int) {
+    for _, e := range plan {
+        skip := false
+        for _, p := range patterns {
+            if excluded(e.Path, p) {
+                skip = true
+                break
+            }
+        }
+        if skip {
+            count++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
+}
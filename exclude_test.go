@@ -0,0 +1,80 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestExcluded(t *testing.T) {
+    tests := []struct {
+        f, pattern string
+        want       bool
+    }{
+        {"a.log", "*.log", true},
+        {"dir/a.log", "*.log", true},
+        {"dir/a.go", "*.log", false},
+        {"dir/a.go", "dir", true},
+        {"dir/a.go", "dir/", true},
+        {"dir", "dir/", false},
+        {"sub/dir/a.go", "/dir", false},
+        {"sub/dir/a.go", "sub/dir", true},
+    }
+    for _, tt := range tests {
+        if got := excluded(tt.f, tt.pattern); got != tt.want {
+            t.Errorf("excluded(%q, %q) = %v, want %v", tt.f, tt.pattern, got, tt.want)
+        }
+    }
+}
+
+// Files matching --exclude or a pattern of --exclude-from files, which
+// may have comments and blank lines, keep their time.
+func TestExcludeRun(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"keep.go": "1", "a.log": "1", "vendor/lib.go": "1", "docs/x.md": "1", "#hash": "1"})
+    dir := t.TempDir()
+    first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+    if err := os.WriteFile(first, []byte("# logs are rebuilt\n*.log\n\n  \nvendor/\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(second, []byte("\\#hash\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now().Truncate(time.Second)
+    for _, f := range []string{"keep.go", "a.log", "vendor/lib.go", "docs/x.md", "#hash"} {
+        r.setMtime(f, now)
+    }
+
+    if run := r.run("--exclude-from", first, "--exclude-from", second, "--exclude", "docs"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("keep.go").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("keep.go not retimed")
+    }
+    for _, f := range []string{"a.log", "vendor/lib.go", "docs/x.md", "#hash"} {
+        if !r.mtime(f).Equal(now) {
+            t.Errorf("excluded %v retimed", f)
+        }
+    }
+}
+
+// Relative --exclude-from is read in the repository given, like other
+// file options, not where gitime was started.
+func TestExcludeFromInRepository(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"keep.go": "1", "skip.log": "1", "excludes": "*.log\n"})
+
+    run := runGitime(t, t.TempDir(), "", "--dry-run", "--exclude-from", "excludes", r.Dir)
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    got := run.times(t)
+    if _, ok := got["skip.log"]; ok || len(got) != 2 {
+        t.Errorf("got %v, want keep.go and excludes only", got)
+    }
+
+    if run := runGitime(t, r.Dir, "", "--dry-run", "--exclude-from", "missing"); run.Code != 2 {
+        t.Errorf("missing file: exit status %d, want 2", run.Code)
+    }
+}
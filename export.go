@@ -0,0 +1,103 @@
+package main
+
+import (
+    "archive/tar"
+    "errors"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Exporting tracked files to another directory
+//------------------------------------------------------------
+
+// Copies files of HEAD matching pathspecs to directory dest, as git
+// archive | tar -x would, so export-ignore and export-subst apply.
+// Dest must be empty or not exist yet, nothing there is overwritten.
+// Returns paths of files written.
+func exportTree(dest string, pathspecs []string) (files map[string]bool, err error) {
+    if entries, err := os.ReadDir(dest); err == nil && len(entries) > 0 {
+        return nil, errors.New("export directory is not empty: " + dest)
+    }
+    if err = os.MkdirAll(dest, 0755); err != nil {
+        return
+    }
+
+    // Many pathspecs take an archive for each batch of them
+    files = map[string]bool{}
+    for _, batch := range pathspecBatches(pathspecs) {
+        // Archive is extracted as git writes it, never held whole
+        extract := func(archive io.Reader) error {
+            return extractExport(dest, archive, files)
+        }
+        if err = runGitStream(extract, withPathspecs([]string{"archive", "--format=tar", "HEAD"}, batch)...); err != nil {
+            return nil, err
+        }
+    }
+    return
+}
+
+// Extracts tar archive of git archive to dest, adding paths of files
+// written to files. Those already there, from an earlier batch, are
+// skipped.
+func extractExport(dest string, archive io.Reader, files map[string]bool) error {
+    tr := tar.NewReader(archive)
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+
+        name := path.Clean(hdr.Name)
+        if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+            return errors.New("archive entry outside of the tree: " + hdr.Name)
+        }
+        if files[name] {
+            continue
+        }
+        fpath := filepath.Join(dest, filepath.FromSlash(name))
+
+        switch hdr.Typeflag {
+        case tar.TypeDir:
+            err = os.MkdirAll(fpath, 0755)
+        case tar.TypeSymlink:
+            err = os.Symlink(hdr.Linkname, fpath)
+            files[name] = true
+        case tar.TypeReg:
+            err = writeExported(fpath, tr, os.FileMode(hdr.Mode).Perm())
+            files[name] = true
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// Writes exported file, failing if it exists.
+func writeExported(fpath string, r io.Reader, perm os.FileMode) error {
+    f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+    if err != nil {
+        return err
+    }
+    if _, err = io.Copy(f, r); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// Keeps files of the plan that were exported.
+func filterExported(plan []planEntry, files map[string]bool) (kept []planEntry) {
+    for _, e := range plan {
+        if files[e.Path] {
+            kept = append(kept, e)
+        }
+    }
+    return
+}
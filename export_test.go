@@ -0,0 +1,89 @@
+package main
+
+import (
+    "archive/tar"
+    "errors"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Export writes files of HEAD to another directory with their commit
+// times, leaving out those marked export-ignore and the work tree as
+// it is.
+func TestExport(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{
+        "a":              "1",
+        "sub/b":          "1",
+        "skip":           "1",
+        ".gitattributes": "skip export-ignore\n",
+    })
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"sub/b": "2"})
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("a", now)
+
+    dest := filepath.Join(t.TempDir(), "export")
+    if run := r.run("--export", dest); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+
+    for f, want := range map[string]string{"a": "2020-01-01T00:00:00Z", "sub/b": "2021-01-01T00:00:00Z"} {
+        info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(f)))
+        if err != nil {
+            t.Errorf("%v not exported: %v", f, err)
+            continue
+        }
+        if !info.ModTime().Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, info.ModTime(), want)
+        }
+    }
+    if _, err := os.Stat(filepath.Join(dest, "skip")); !os.IsNotExist(err) {
+        t.Errorf("export-ignore file exported: %v", err)
+    }
+    if got := r.mtime("a"); !got.Equal(now) {
+        t.Errorf("work tree retimed: got %v", got)
+    }
+}
+
+// Archives are read as git writes them. Failing to read one stops git
+// with the reader's error, a failing git gives its own.
+func TestRunGitStream(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": strings.Repeat("a", 1<<20), "b": "1"})
+    r.enter()
+
+    var names []string
+    err := runGitStream(func(archive io.Reader) error {
+        tr := tar.NewReader(archive)
+        for {
+            hdr, err := tr.Next()
+            if err == io.EOF {
+                return nil
+            }
+            if err != nil {
+                return err
+            }
+            if hdr.Typeflag == tar.TypeReg {
+                names = append(names, hdr.Name)
+            }
+        }
+    }, "archive", "--format=tar", "HEAD")
+    if err != nil || strings.Join(names, " ") != "a b" {
+        t.Errorf("got %v: %v", names, err)
+    }
+
+    stopped := errors.New("stopped")
+    if err := runGitStream(func(io.Reader) error { return stopped }, "archive", "--format=tar", "HEAD"); err != stopped {
+        t.Errorf("got %v, want error of reader", err)
+    }
+
+    var gerr *gitError
+    err = runGitStream(func(io.Reader) error { return nil }, "archive", "--format=tar", "no-such-ref")
+    if !errors.As(err, &gerr) || gerr.ExitCode <= 0 {
+        t.Errorf("got %v, want git error", err)
+    }
+}
@@ -0,0 +1,1101 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//------------------------------------------------------------
+// Running git
+//------------------------------------------------------------
+
+// Git executable to run, a name looked up in PATH or a path.
+var gitExecutable = "git"
+
+// Runs git with given arguments and standard input, returning its standard
+// output and error separately. Replaceable to feed canned git output
+// instead of a real repository.
+// Pager is off in case config enables one for a command, output goes to
+// a pipe anyway. A wrapper script printing its own text on standard
+// output can't be told from git, --git-path gets past it.
+var gitRunner = func(stdin []byte, args ...string) (stdout, stderr []byte, err error) {
+    var outBuf, errBuf bytes.Buffer
+    cmd := exec.Command(gitExecutable, append([]string{"--no-pager"}, args...)...)
+    if stdin != nil {
+        cmd.Stdin = bytes.NewReader(stdin)
+    }
+    cmd.Stdout = &outBuf
+    cmd.Stderr = &errBuf
+    err = cmd.Run()
+    return outBuf.Bytes(), errBuf.Bytes(), err
+}
+
+// Failed git command.
+type gitError struct {
+    Args     []string // Arguments git was run with
+    ExitCode int      // Exit status, -1 if git didn't run or was killed
+    Stderr   string   // What git printed on standard error
+    Err      error    // Error running git
+}
+
+func (e *gitError) Error() string {
+    argv := gitArgv(e.Args)
+    if e.ExitCode == -1 {
+        return fmt.Sprintf("%v: %v", argv, e.Err)
+    }
+    if e.Stderr == "" {
+        return fmt.Sprintf("%v: exit status %d", argv, e.ExitCode)
+    }
+    return fmt.Sprintf("%v: exit status %d: %v", argv, e.ExitCode, e.Stderr)
+}
+
+func (e *gitError) Unwrap() error {
+    return e.Err
+}
+
+// Formats git command line, quoting arguments as needed to be read back.
+func gitArgv(args []string) string {
+    argv := "git"
+    for _, arg := range args {
+        if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+            arg = strconv.Quote(arg)
+        }
+        argv += " " + arg
+    }
+    return argv
+}
+
+// Log every git command run and how long it took.
+var gitTrace bool
+
+// Top of work tree to run git commands in, once known.
+// Paths git prints are then relative to it wherever gitime was started.
+var gitWorkTree string
+
+// Runs git command, returning only its standard output for parsing.
+// Messages git prints on success, like CRLF warnings, are passed on
+// as warnings.
+func runGit(args ...string) (out []byte, err error) {
+    return runGitInput(nil, args...)
+}
+
+// Runs git command feeding it standard input, see runGit.
+func runGitInput(stdin []byte, args ...string) (out []byte, err error) {
+    if gitWorkTree != "" {
+        args = append([]string{"-C", gitWorkTree}, args...)
+    }
+
+    var started time.Time
+    if gitTrace {
+        fmt.Fprintf(os.Stderr, "TRACE %v\n", gitArgv(args))
+        started = time.Now()
+    }
+    out, stderr, err := gitRunner(stdin, args...)
+    if gitTrace {
+        fmt.Fprintf(os.Stderr, "TRACE done in %v\n", time.Since(started).Round(time.Microsecond))
+    }
+    if err = gitOutcome(args, stderr, err); err != nil {
+        return nil, err
+    }
+    return
+}
+
+// Runs git command handing its standard output to read as it comes, for
+// output too big to hold, like archives. Errors are those of runGit, or
+// of read, which git is killed on. Git of gitRunner isn't used, this
+// needs a real one.
+func runGitStream(read func(stdout io.Reader) error, args ...string) error {
+    if gitWorkTree != "" {
+        args = append([]string{"-C", gitWorkTree}, args...)
+    }
+
+    var started time.Time
+    if gitTrace {
+        fmt.Fprintf(os.Stderr, "TRACE %v\n", gitArgv(args))
+        started = time.Now()
+    }
+    var errBuf bytes.Buffer
+    cmd := exec.Command(gitExecutable, append([]string{"--no-pager"}, args...)...)
+    cmd.Stderr = &errBuf
+    stdout, err := cmd.StdoutPipe()
+    if err == nil {
+        err = cmd.Start()
+    }
+    if err != nil {
+        return gitOutcome(args, nil, err)
+    }
+
+    // Readers may stop at the end of their data, before padding after it
+    readErr := read(stdout)
+    if readErr == nil {
+        _, readErr = io.Copy(io.Discard, stdout)
+    }
+    if readErr != nil {
+        cmd.Process.Kill()
+        cmd.Wait()
+        return readErr
+    }
+    err = cmd.Wait()
+    if gitTrace {
+        fmt.Fprintf(os.Stderr, "TRACE done in %v\n", time.Since(started).Round(time.Microsecond))
+    }
+    return gitOutcome(args, errBuf.Bytes(), err)
+}
+
+// Error of git command run with arguments, from what it printed on
+// standard error and the error running it. Messages printed on success
+// are passed on as warnings.
+func gitOutcome(args []string, stderr []byte, err error) error {
+    msg := strings.TrimSpace(string(stderr))
+    if err != nil {
+        gerr := &gitError{Args: args, ExitCode: -1, Stderr: msg, Err: err}
+        var exitErr *exec.ExitError
+        if errors.As(err, &exitErr) {
+            gerr.ExitCode = exitErr.ExitCode()
+        }
+        return gerr
+    }
+
+    if msg != "" {
+        for _, line := range strings.Split(msg, "\n") {
+            fmt.Fprintf(os.Stderr, "WARNING git: %v\n", line)
+        }
+    }
+    return nil
+}
+
+// Appends pathspecs to git arguments, if any.
+func withPathspecs(args []string, pathspecs []string) []string {
+    if len(pathspecs) == 0 {
+        return args
+    }
+    return append(append(args, "--"), pathspecs...)
+}
+
+// Most bytes of pathspecs passed as arguments. Command lines are limited,
+// to 32K characters on Windows, so more are batched or go to stdin.
+const pathspecArgBytes = 16 << 10
+
+// Tells if pathspecs fit a command line.
+func pathspecsFit(pathspecs []string) bool {
+    n := 0
+    for _, p := range pathspecs {
+        n += len(p) + 1
+    }
+    return n <= pathspecArgBytes
+}
+
+// Arguments and standard input of a git log or rev-list command with
+// pathspecs. Too many to fit the command line are read by git from
+// stdin after a "--" line, which fits no pathspec with a newline.
+func withStdinPathspecs(args []string, pathspecs []string) (stdin []byte, argv []string) {
+    if pathspecsFit(pathspecs) {
+        return nil, withPathspecs(args, pathspecs)
+    }
+
+    input := bytes.NewBufferString("--\n")
+    for _, p := range pathspecs {
+        input.WriteString(p + "\n")
+    }
+    return input.Bytes(), append(args, "--stdin")
+}
+
+// Splits pathspecs into batches fitting a command line, for commands
+// listing files which can be run once for each and their output joined.
+// Exclusions apply to all files so each batch has all of them.
+func pathspecBatches(pathspecs []string) (batches [][]string) {
+    if pathspecsFit(pathspecs) {
+        return [][]string{pathspecs}
+    }
+
+    var includes, excludes []string
+    size := 0
+    for _, p := range pathspecs {
+        if strings.HasPrefix(p, ":(exclude") || strings.HasPrefix(p, ":!") || strings.HasPrefix(p, ":^") {
+            excludes = append(excludes, p)
+            size += len(p) + 1
+        } else {
+            includes = append(includes, p)
+        }
+    }
+
+    var batch []string
+    n := size
+    for _, p := range includes {
+        if len(batch) > 0 && n+len(p)+1 > pathspecArgBytes {
+            batches = append(batches, append(batch, excludes...))
+            batch, n = nil, size
+        }
+        batch = append(batch, p)
+        n += len(p) + 1
+    }
+    if len(batch) > 0 || len(batches) == 0 {
+        batches = append(batches, append(batch, excludes...))
+    }
+    return
+}
+
+// Runs git command listing NUL separated files once for each batch of
+// pathspecs, returning files in order first listed. Overlapping
+// pathspecs list files in more than one batch, they are kept once.
+func gitBatchedFiles(args []string, pathspecs []string) (files []string, err error) {
+    batches := pathspecBatches(pathspecs)
+    seen := map[string]bool{}
+    for _, batch := range batches {
+        out, err := runGit(withPathspecs(append([]string{}, args...), batch)...)
+        if err != nil {
+            return nil, err
+        }
+        for _, f := range strings.Split(string(out), "\x00") {
+            if f == "" || len(batches) > 1 && seen[f] {
+                continue
+            }
+            seen[f] = true
+            files = append(files, f)
+        }
+    }
+    return
+}
+
+// Layouts of times git prints: strict ISO 8601 of %aI, the default of
+// %ad, ISO 8601 like of %ai and RFC 2822 of %aD.
+var gitTimeLayouts = []string{
+    time.RFC3339,
+    "Mon Jan 2 15:04:05 2006 -0700",
+    "2006-01-02 15:04:05 -0700",
+    "Mon, 2 Jan 2006 15:04:05 -0700",
+}
+
+// Parse times in all layouts git prints if the one asked for fails, for
+// git or wrappers printing legacy formats whatever was asked.
+var gitTimeFallback bool
+
+// Parses time as git prints it, in any of given layouts, or of all
+// git prints if none is given. Callers knowing the format they asked
+// git for should pass it, a fallback could hide a wrong one, unless
+// told to fall back. Exported as the one way gitime reads git times,
+// for code built with it to read them the same way.
+func ParseGitTime(raw string, layouts ...string) (t time.Time, err error) {
+    if len(layouts) == 0 || gitTimeFallback {
+        layouts = append(layouts, gitTimeLayouts...)
+    }
+    for _, layout := range layouts {
+        if t, err = time.Parse(layout, raw); err == nil {
+            return
+        }
+    }
+    return t, errors.New("Could not understand this time stamp: " + raw)
+}
+
+// Commit time fields tried in order for times of history, first sane
+// one wins, see --date-order.
+var gitDateOrder = []string{"author"}
+
+// Parses --date-order, commit time fields separated by commas.
+func parseDateOrder(s string) (order []string, err error) {
+    seen := map[string]bool{}
+    for _, field := range strings.Split(s, ",") {
+        if field != "author" && field != "commit" {
+            return nil, fmt.Errorf("unknown date field %q, must be author or commit", field)
+        }
+        if seen[field] {
+            return nil, fmt.Errorf("date field %v given twice", field)
+        }
+        seen[field] = true
+        order = append(order, field)
+    }
+    return
+}
+
+// Tells if commit time is sane: after the Unix epoch, which git shows
+// for missing or broken dates, and less than a year from now.
+// Anything else is absurd for a real commit.
+func saneDate(t time.Time) bool {
+    return t.Unix() > 0 && t.Before(time.Now().AddDate(1, 0, 0))
+}
+
+// Picks time of commit from its fields as --date-order tells, the
+// first sane one, or the last one there if none is.
+func pickDate(dates map[string]time.Time) (date time.Time, ok bool) {
+    for _, field := range gitDateOrder {
+        t, found := dates[field]
+        if !found {
+            continue
+        }
+        date, ok = t, true
+        if saneDate(t) {
+            return
+        }
+    }
+    return
+}
+
+// Returns top directory of the work tree.
+// Being the first git output read, it is checked for text of a wrapper
+// script, which would otherwise break parsing much later.
+func gitTopLevel() (dir string, err error) {
+    out, err := runGit("rev-parse", "--show-toplevel")
+    if err != nil {
+        return
+    }
+
+    dir = strings.TrimSuffix(string(out), "\n")
+    if strings.Contains(dir, "\n") || !filepath.IsAbs(dir) {
+        return "", fmt.Errorf("unexpected output of %v, a wrapper printing text? use --git-path: %q", gitExecutable, dir)
+    }
+    return
+}
+
+// Returns absolute path of the git directory.
+// Older git only gives it relative to where it runs.
+func gitAbsoluteDir() (dir string, err error) {
+    if !gitSupports("absolute-git-dir") {
+        out, err := runGit("rev-parse", "--git-dir")
+        if err != nil {
+            return "", err
+        }
+        dir = strings.TrimSuffix(string(out), "\n")
+        if !filepath.IsAbs(dir) {
+            dir = filepath.Join(gitWorkTree, dir)
+        }
+        return filepath.Abs(dir)
+    }
+
+    out, err := runGit("rev-parse", "--absolute-git-dir")
+    if err != nil {
+        return
+    }
+
+    dir = strings.TrimSuffix(string(out), "\n")
+    return
+}
+
+// Tells if repository is a shallow clone.
+// Older git can't tell, the shallow file in git directory does.
+func gitIsShallow() (shallow bool, err error) {
+    if !gitSupports("is-shallow") {
+        gitDir, err := gitAbsoluteDir()
+        if err != nil {
+            return false, err
+        }
+        _, err = os.Stat(filepath.Join(gitDir, "shallow"))
+        if os.IsNotExist(err) {
+            return false, nil
+        }
+        return err == nil, err
+    }
+
+    out, err := runGit("rev-parse", "--is-shallow-repository")
+    if err != nil {
+        return
+    }
+
+    shallow = strings.TrimSpace(string(out)) == "true"
+    return
+}
+
+// Reads number of entries in the index from its header, none if there's
+// no index file. Not ok if the header isn't that of an index.
+func gitIndexEntries() (n int, ok bool, err error) {
+    gitDir, err := gitAbsoluteDir()
+    if err != nil {
+        return
+    }
+    f, err := os.Open(filepath.Join(gitDir, "index"))
+    if os.IsNotExist(err) {
+        return 0, true, nil
+    }
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    // Header is "DIRC", version and entry count, 4 bytes each big endian
+    header := make([]byte, 12)
+    if _, err := io.ReadFull(f, header); err != nil || string(header[:4]) != "DIRC" {
+        return 0, false, nil
+    }
+    return int(binary.BigEndian.Uint32(header[8:])), true, nil
+}
+
+// Re-creates the index from HEAD, as after a missing or corrupt one.
+// Stat data of files is refreshed by git as needed.
+func gitReadTree() error {
+    _, err := runGit("read-tree", "HEAD")
+    return err
+}
+
+// Lists git file modes of all paths at HEAD, like "100644" or "120000".
+func gitTreeModes() (modes map[string]string, err error) {
+    out, err := runGit("ls-tree", "-r", "-z", "--full-tree", "HEAD")
+    if err != nil {
+        return
+    }
+
+    // Each record is "<mode> <type> <object>\t<file>"
+    modes = map[string]string{}
+    for _, rec := range strings.Split(string(out), "\x00") {
+        idx := strings.IndexByte(rec, '\t')
+        sp := strings.IndexByte(rec, ' ')
+        if idx == -1 || sp == -1 || sp > idx {
+            continue
+        }
+        modes[rec[idx+1:]] = rec[:sp]
+    }
+    return
+}
+
+// Version of git once read, it doesn't change while running.
+var gitVersionSeen string
+
+// Returns git version string, like "2.39.5".
+func gitVersion() (version string, err error) {
+    if gitVersionSeen != "" {
+        return gitVersionSeen, nil
+    }
+    out, err := runGit("--version")
+    if err != nil {
+        return
+    }
+
+    version = strings.TrimPrefix(strings.TrimSpace(string(out)), "git version ")
+    gitVersionSeen = version
+    return
+}
+
+// Tells if git version is at least major.minor.
+func gitVersionAtLeast(major, minor int) (ok bool, err error) {
+    version, err := gitVersion()
+    if err != nil {
+        return
+    }
+
+    // Vendors append to the version, like "2.39.5.windows.1"
+    parts := strings.SplitN(version, ".", 3)
+    if len(parts) < 2 {
+        return false, errors.New("Could not understand git version: " + version)
+    }
+    maj, err1 := strconv.Atoi(parts[0])
+    mnr, err2 := strconv.Atoi(parts[1])
+    if err1 != nil || err2 != nil {
+        return false, errors.New("Could not understand git version: " + version)
+    }
+    return maj > major || maj == major && mnr >= minor, nil
+}
+
+// A git feature and the least version having it.
+type gitFeature struct {
+    Name         string // What it is, for messages
+    Major, Minor int
+}
+
+// Features of git gitime relies on that older versions lack. Those with
+// a fallback are checked before use, the others fail with a clear message.
+var gitFeatures = map[string]gitFeature{
+    "iso-dates":        {"strict ISO 8601 dates of %aI and %cI", 2, 2},
+    "absolute-git-dir": {"rev-parse --absolute-git-dir", 2, 13},
+    "is-shallow":       {"rev-parse --is-shallow-repository", 2, 15},
+    "commit-graph":     {"commit-graph", 2, 18},
+}
+
+// Tells if git has feature of gitFeatures. A version that can't be read,
+// as of a build with odd version string, is taken to have all.
+func gitSupports(feature string) bool {
+    f := gitFeatures[feature]
+    ok, err := gitVersionAtLeast(f.Major, f.Minor)
+    return ok || err != nil
+}
+
+// Fails unless git has feature, naming the least version needed.
+func requireGit(feature string) error {
+    if gitSupports(feature) {
+        return nil
+    }
+    f := gitFeatures[feature]
+    version, _ := gitVersion()
+    return fmt.Errorf("%v requires git >= %d.%d, found %v", f.Name, f.Major, f.Minor, version)
+}
+
+// Tells if repository has a commit-graph file, single or split in a chain.
+func gitHasCommitGraph() (has bool, err error) {
+    for _, name := range []string{"objects/info/commit-graph", "objects/info/commit-graphs/commit-graph-chain"} {
+        out, err := runGit("rev-parse", "--git-path", name)
+        if err != nil {
+            return false, err
+        }
+        fpath := strings.TrimSpace(string(out))
+        if !filepath.IsAbs(fpath) {
+            fpath = filepath.Join(gitWorkTree, fpath)
+        }
+        if _, err := os.Stat(fpath); err == nil {
+            return true, nil
+        }
+    }
+    return
+}
+
+// Writes commit-graph of all reachable commits.
+func gitWriteCommitGraph() (err error) {
+    _, err = runGit("commit-graph", "write", "--reachable")
+    return
+}
+
+// Returns hash of HEAD commit.
+func gitHead() (hash string, err error) {
+    out, err := runGit("rev-parse", "HEAD")
+    if err != nil {
+        return
+    }
+
+    hash = strings.TrimSpace(string(out))
+    return
+}
+
+// Returns full hash of given commit, which must exist.
+func gitResolveCommit(rev string) (hash string, err error) {
+    out, err := runGit("rev-parse", "--verify", "--quiet", rev+"^{commit}")
+    if err != nil {
+        return hash, errors.New("no such commit")
+    }
+
+    hash = strings.TrimSpace(string(out))
+    return
+}
+
+// Returns full hash and committer time of given commit, which must exist.
+func gitCommitDate(rev string) (hash string, date time.Time, err error) {
+    if hash, err = gitResolveCommit(rev); err != nil {
+        return
+    }
+
+    out, err := runGit("show", "-s", "--format=%cI", hash)
+    if err != nil {
+        return
+    }
+
+    raw := strings.TrimSpace(string(out))
+    date, err = ParseGitTime(raw, time.RFC3339)
+    return
+}
+
+// Returns hash and author time of the newest commit changing given
+// lines of a file, following them through history like git blame.
+func gitLineRangeCommit(file string, start, end int) (hash string, date time.Time, err error) {
+    out, err := runGit("log", "-1", "-s", "--format=%H %aI", fmt.Sprintf("-L%d,%d:%v", start, end, file))
+    if err != nil {
+        return
+    }
+
+    hash, raw, ok := strings.Cut(strings.TrimSpace(string(out)), " ")
+    if !ok {
+        return hash, date, errors.New("no commit changed these lines")
+    }
+    date, err = ParseGitTime(raw, time.RFC3339)
+    return
+}
+
+// Returns hash and time of the newest commit among those of all lines of
+// a file at HEAD, as git blame tells. Times are picked like those of
+// history, see --date-order. No commit for an empty file.
+func gitBlameNewest(file string) (hash string, date time.Time, err error) {
+    out, err := runGit("blame", "--porcelain", "HEAD", "--", file)
+    if err != nil {
+        return
+    }
+
+    // Each line is "<hash> <orig> <final> [<count>]", with headers of
+    // the commit the first time it's seen, then a tab and the content
+    fields := map[string]map[string]time.Time{}
+    var current string
+    var sec int64
+    for _, line := range strings.Split(string(out), "\n") {
+        key, value, _ := strings.Cut(line, " ")
+        switch {
+        case (len(key) == 40 || len(key) == 64) && value != "":
+            current = key
+            if fields[current] == nil {
+                fields[current] = map[string]time.Time{}
+            }
+        case key == "author-time" || key == "committer-time":
+            if sec, err = strconv.ParseInt(value, 10, 64); err != nil {
+                return "", date, fmt.Errorf("unexpected blame header: %v", line)
+            }
+        case key == "author-tz" || key == "committer-tz":
+            t := time.Unix(sec, 0)
+            if z, err := time.Parse("-0700", value); err == nil {
+                t = t.In(z.Location())
+            }
+            field := "author"
+            if key == "committer-tz" {
+                field = "commit"
+            }
+            fields[current][field] = t
+        }
+    }
+
+    for h, f := range fields {
+        if t, ok := pickDate(f); ok && t.After(date) {
+            hash, date = h, t
+        }
+    }
+    return
+}
+
+// Returns author time of the commit first adding each file, among
+// commits selected by git log arguments if any and matching pathspecs
+// if any. Renames aren't followed, a renamed file is added by the rename.
+func gitAddedTimes(revArgs []string, pathspecs []string) (times map[string]time.Time, err error) {
+    args := append([]string{"log", "-z", "--name-only", "--no-renames", "--diff-filter=A", "--format=%x01%aI"}, revArgs...)
+    stdin, argv := withStdinPathspecs(args, pathspecs)
+    out, err := runGitInput(stdin, argv...)
+    if err != nil {
+        return
+    }
+
+    // Each commit is "\x01<date>\x00" followed by files, the first of
+    // them after a newline. Commits are newest first, oldest add wins
+    times = map[string]time.Time{}
+    var date time.Time
+    for _, f := range strings.Split(string(out), "\x00") {
+        if strings.HasPrefix(f, "\x01") {
+            raw := f[1:]
+            if date, err = ParseGitTime(raw, time.RFC3339); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        f = strings.TrimPrefix(f, "\n")
+        if f != "" {
+            times[f] = date
+        }
+    }
+    return
+}
+
+// Returns author time of the oldest root commit of HEAD.
+func gitFirstCommitDate() (date time.Time, err error) {
+    out, err := runGit("log", "--max-parents=0", "--pretty=%aI", "HEAD")
+    if err != nil {
+        return
+    }
+
+    for _, raw := range strings.Fields(string(out)) {
+        t, err := ParseGitTime(raw, time.RFC3339)
+        if err != nil {
+            return date, err
+        }
+        if date.IsZero() || t.Before(date) {
+            date = t
+        }
+    }
+    if date.IsZero() {
+        err = errors.New("no commits")
+    }
+    return
+}
+
+// Returns times noted on commits in notes ref, by commit hash.
+// A note holds an RFC3339 time on its first line. Notes not holding
+// a time are skipped with a warning, a missing ref has no notes.
+func gitNoteTimes(ref string) (times map[string]time.Time, err error) {
+    out, err := runGit("notes", "--ref="+ref, "list")
+    if err != nil {
+        return
+    }
+
+    // Each line is "<note-object> <commit>", notes are read at once
+    var input bytes.Buffer
+    var commits []string
+    for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            continue
+        }
+        input.WriteString(fields[0] + "\n")
+        commits = append(commits, fields[1])
+    }
+    times = map[string]time.Time{}
+    if len(commits) == 0 {
+        return
+    }
+    out, err = runGitInput(input.Bytes(), "cat-file", "--batch")
+    if err != nil {
+        return nil, err
+    }
+
+    // Each object is "<hash> <type> <size>\n<content>\n", in order asked
+    for _, hash := range commits {
+        idx := bytes.IndexByte(out, '\n')
+        if idx == -1 {
+            return nil, errors.New("cat-file printed fewer notes than listed")
+        }
+        header := strings.Fields(string(out[:idx]))
+        out = out[idx+1:]
+        if len(header) != 3 || header[1] != "blob" {
+            return nil, fmt.Errorf("unexpected cat-file object: %v", strings.Join(header, " "))
+        }
+        size, err := strconv.Atoi(header[2])
+        if err != nil || size > len(out) {
+            return nil, fmt.Errorf("unexpected cat-file object: %v", strings.Join(header, " "))
+        }
+        note := out[:size]
+        out = out[min(size+1, len(out)):]
+
+        raw, _, _ := strings.Cut(strings.TrimSpace(string(note)), "\n")
+        t, err := time.Parse(time.RFC3339, strings.TrimSpace(raw))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "WARNING note of commit %v holds no RFC3339 time: %v\n", hash, raw)
+            continue
+        }
+        times[hash] = t
+    }
+    return
+}
+
+// Lists files changed in each of the commits, matching pathspecs if any,
+// with a single diff-tree call for each batch of pathspecs. Merges list
+// files as git show does.
+func gitCommitsFiles(hashes []string, pathspecs []string) (files map[string][]string, err error) {
+    var input bytes.Buffer
+    for _, hash := range hashes {
+        input.WriteString(hash + "\n")
+    }
+
+    batches := pathspecBatches(pathspecs)
+    files = map[string][]string{}
+    seen := map[string]bool{}
+    for _, batch := range batches {
+        args := []string{"diff-tree", "--stdin", "-z", "-r", "--root", "--cc", "--always", "--name-only"}
+        out, err := runGitInput(input.Bytes(), withPathspecs(args, batch)...)
+        if err != nil {
+            return nil, err
+        }
+
+        // Each commit is its hash followed by its files, NUL separated.
+        // All commits are listed and in order, so the next hash tells one
+        // commit from another.
+        next, cur := 0, ""
+        for _, f := range strings.Split(string(out), "\x00") {
+            if next < len(hashes) && f == hashes[next] {
+                cur = hashes[next]
+                next++
+                continue
+            }
+            if f == "" || cur == "" {
+                continue
+            }
+            if len(batches) > 1 {
+                if seen[cur+"\x00"+f] {
+                    continue
+                }
+                seen[cur+"\x00"+f] = true
+            }
+            files[cur] = append(files[cur], f)
+        }
+    }
+    return
+}
+
+// Returns files whose mode alone changed in each commit of git log
+// arguments, their content kept as is. Merges show no raw diff in log
+// so none of their files are listed.
+func gitModeOnlyChanges(revArgs []string, pathspecs []string) (changes map[string]map[string]bool, err error) {
+    args := append([]string{"log", "-z", "--raw", "--no-renames", "--no-abbrev", "--format=%x01%H"}, revArgs...)
+    stdin, argv := withStdinPathspecs(args, pathspecs)
+    out, err := runGitInput(stdin, argv...)
+    if err != nil {
+        return
+    }
+
+    // Each commit is "\x01<hash>\0", then "\n" before the first change.
+    // Each change is ":<old-mode> <new-mode> <old-blob> <new-blob> <status>\0<path>\0"
+    changes = map[string]map[string]bool{}
+    fields := strings.Split(string(out), "\x00")
+    cur := ""
+    for i := 0; i < len(fields); i++ {
+        f := strings.TrimPrefix(fields[i], "\n")
+        switch {
+        case strings.HasPrefix(f, "\x01"):
+            cur = f[1:]
+        case strings.HasPrefix(f, ":") && i+1 < len(fields):
+            i++
+            raw := strings.Fields(f[1:])
+            if len(raw) == 5 && raw[4] == "M" && raw[2] == raw[3] {
+                if changes[cur] == nil {
+                    changes[cur] = map[string]bool{}
+                }
+                changes[cur][fields[i]] = true
+            }
+        }
+    }
+    return
+}
+
+// Returns time of each of the commits as --date-order picks it, reading
+// them all through a single cat-file call.
+func gitCommitTimes(hashes []string) (dates map[string]time.Time, err error) {
+    var input bytes.Buffer
+    for _, hash := range hashes {
+        input.WriteString(hash + "\n")
+    }
+
+    out, err := runGitInput(input.Bytes(), "cat-file", "--batch")
+    if err != nil {
+        return
+    }
+
+    // Each object is "<hash> <type> <size>\n<content>\n"
+    dates = map[string]time.Time{}
+    for len(out) > 0 {
+        idx := bytes.IndexByte(out, '\n')
+        if idx == -1 {
+            break
+        }
+        header := strings.Fields(string(out[:idx]))
+        out = out[idx+1:]
+        if len(header) != 3 || header[1] != "commit" {
+            return nil, fmt.Errorf("unexpected cat-file object: %v", strings.Join(header, " "))
+        }
+        size, err := strconv.Atoi(header[2])
+        if err != nil || size > len(out) {
+            return nil, fmt.Errorf("unexpected cat-file object: %v", strings.Join(header, " "))
+        }
+
+        fields := map[string]time.Time{}
+        for _, field := range gitDateOrder {
+            if date, err := headerDate(out[:size], field); err == nil {
+                fields[field] = date
+            }
+        }
+        date, ok := pickDate(fields)
+        if !ok {
+            return nil, errors.New("Could not understand this commit date: " + string(out[:size]))
+        }
+        dates[header[0]] = date
+        out = out[min(size+1, len(out)):]
+    }
+    return
+}
+
+// Parses time of raw commit object, of the author or commit field.
+// Time zone is that of the offset like time.Parse gives for git show.
+func headerDate(commit []byte, field string) (date time.Time, err error) {
+    prefix := "author "
+    if field == "commit" {
+        prefix = "committer "
+    }
+    for _, line := range strings.Split(string(commit), "\n") {
+        if line == "" {
+            // End of headers
+            break
+        }
+        if !strings.HasPrefix(line, prefix) {
+            continue
+        }
+
+        // Line is "author <name> <<email>> <seconds> <offset>"
+        fields := strings.Fields(line[strings.LastIndexByte(line, '>')+1:])
+        if len(fields) != 2 {
+            break
+        }
+        sec, err := strconv.ParseInt(fields[0], 10, 64)
+        if err != nil {
+            break
+        }
+        zone, err := time.Parse("-0700", fields[1])
+        if err != nil {
+            break
+        }
+        return time.Unix(sec, 0).In(zone.Location()), nil
+    }
+    return date, errors.New("Could not understand this commit " + field + ": " + string(commit))
+}
+
+// Returns the commits and all their ancestors, parents before children.
+func gitTopoOrder(hashes []string) (order []string, err error) {
+    var input bytes.Buffer
+    for _, hash := range hashes {
+        input.WriteString(hash + "\n")
+    }
+
+    out, err := runGitInput(input.Bytes(), "rev-list", "--stdin", "--topo-order", "--reverse")
+    if err != nil {
+        return
+    }
+    order = strings.Fields(string(out))
+    return
+}
+
+// A tag of a commit with its time, that of tagging if annotated.
+type gitTag struct {
+    Ref  string
+    Date time.Time
+}
+
+// Lists tags pointing at commits, oldest first.
+func gitCommitTags() (tags []gitTag, err error) {
+    out, err := runGit("for-each-ref", "--sort=creatordate", "--format=%(objecttype) %(*objecttype) %(creatordate:iso-strict) %(refname)", "refs/tags")
+    if err != nil {
+        return
+    }
+
+    // Each line is "<type> [<peeled-type>] <date> <ref>", peeled type
+    // only set for annotated tags
+    for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+        fields := strings.SplitN(line, " ", 4)
+        if len(fields) != 4 {
+            continue
+        }
+        if fields[0] != "commit" && fields[1] != "commit" {
+            continue
+        }
+        date, err := ParseGitTime(fields[2], time.RFC3339)
+        if err != nil {
+            return nil, err
+        }
+        tags = append(tags, gitTag{Ref: fields[3], Date: date})
+    }
+    return
+}
+
+// Lists commits reachable from rev but from none of the others.
+func gitCommitsNotIn(rev string, others []string) (hashes []string, err error) {
+    var input bytes.Buffer
+    input.WriteString(rev + "\n")
+    for _, other := range others {
+        input.WriteString("^" + other + "\n")
+    }
+
+    out, err := runGitInput(input.Bytes(), "rev-list", "--stdin")
+    if err != nil {
+        return
+    }
+    hashes = strings.Fields(string(out))
+    return
+}
+
+// Lists files differing between two commits, matching pathspecs if any.
+func gitChangedFiles(from, to string, pathspecs []string) (files []string, err error) {
+    return gitBatchedFiles([]string{"diff", "--name-only", "-z", from, to}, pathspecs)
+}
+
+// Lists files with staged changes against HEAD, matching pathspecs if
+// any. Staged deletions are left out, renames are a new file.
+func gitStagedFiles(pathspecs []string) (files []string, err error) {
+    return gitBatchedFiles([]string{"diff", "--cached", "--name-only", "-z", "--no-renames", "--diff-filter=d", "HEAD"}, pathspecs)
+}
+
+// Tells which of the files match ignore rules, whether tracked or not.
+// All files go to a single check-ignore call.
+func gitIgnoredFiles(files []string) (ignored map[string]bool, err error) {
+    var input bytes.Buffer
+    for _, f := range files {
+        input.WriteString(f)
+        input.WriteByte(0)
+    }
+
+    ignored = map[string]bool{}
+    out, err := runGitInput(input.Bytes(), "check-ignore", "--no-index", "--stdin", "-z")
+    var gerr *gitError
+    if errors.As(err, &gerr) && gerr.ExitCode == 1 {
+        // None ignored
+        return ignored, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    for _, f := range strings.Split(string(out), "\x00") {
+        if f != "" {
+            ignored[f] = true
+        }
+    }
+    return
+}
+
+// Tells which of the paths have export-ignore attribute set, as git
+// archive reads it from the index. All paths go to a single check-attr
+// call.
+func gitExportIgnored(paths []string) (ignored map[string]bool, err error) {
+    var input bytes.Buffer
+    for _, p := range paths {
+        input.WriteString(p)
+        input.WriteByte(0)
+    }
+
+    out, err := runGitInput(input.Bytes(), "check-attr", "--cached", "--stdin", "-z", "export-ignore")
+    if err != nil {
+        return
+    }
+
+    // Each record is "<path>\x00<attribute>\x00<value>\x00"
+    ignored = map[string]bool{}
+    recs := strings.Split(string(out), "\x00")
+    for i := 0; i+2 < len(recs); i += 3 {
+        if recs[i+2] == "set" {
+            ignored[recs[i]] = true
+        }
+    }
+    return
+}
+
+// Local index flags of a tracked file.
+type indexFlags struct {
+    AssumeUnchanged bool
+    SkipWorktree    bool
+}
+
+// Returns index flags of tracked files having any set.
+func gitIndexFlags() (flags map[string]indexFlags, err error) {
+    out, err := runGit("ls-files", "-v", "-z")
+    if err != nil {
+        return
+    }
+
+    // Each record is "<tag> <file>", tag is lower case if assume-unchanged
+    // and "S" or "s" if skip-worktree
+    flags = map[string]indexFlags{}
+    for _, rec := range strings.Split(string(out), "\x00") {
+        if len(rec) < 3 || rec[1] != ' ' {
+            continue
+        }
+        tag := rec[0]
+        f := indexFlags{
+            AssumeUnchanged: tag >= 'a' && tag <= 'z',
+            SkipWorktree:    tag == 'S' || tag == 's',
+        }
+        if f.AssumeUnchanged || f.SkipWorktree {
+            flags[rec[2:]] = f
+        }
+    }
+    return
+}
+
+// Tells which tracked files are binary, using git's own detection.
+// A single ls-files call covers the whole index.
+func gitFileClasses() (binary map[string]bool, err error) {
+    out, err := runGit("ls-files", "--eol", "-z")
+    if err != nil {
+        return
+    }
+
+    // Each record is "i/<eol> w/<eol> attr/<attrs>\t<file>"
+    binary = map[string]bool{}
+    for _, rec := range strings.Split(string(out), "\x00") {
+        idx := strings.IndexByte(rec, '\t')
+        if idx == -1 {
+            continue
+        }
+        binary[rec[idx+1:]] = strings.HasPrefix(rec, "i/-text")
+    }
+    return
+}
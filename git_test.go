@@ -0,0 +1,448 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Notes of all commits are read at once, a note without a time is
+// skipped, commits without a note keep their own time.
+func TestGitNoteTimes(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2020-02-01T00:00:00Z", map[string]string{"b": "1"})
+    r.commit("2020-03-01T00:00:00Z", map[string]string{"c": "1"})
+    r.git("notes", "--ref=times", "add", "-m", "2015-05-05T05:05:05Z", "HEAD~2")
+    r.git("notes", "--ref=times", "add", "-m", "not a time", "HEAD~1")
+    r.enter()
+
+    times, err := gitNoteTimes("times")
+    if err != nil {
+        t.Fatal(err)
+    }
+    hash, err := gitResolveCommit("HEAD~2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(times) != 1 || !times[hash].Equal(mustTime(t, "2015-05-05T05:05:05Z")) {
+        t.Errorf("got %v, want 2015-05-05T05:05:05Z for %v only", times, hash)
+    }
+
+    if times, err := gitNoteTimes("none"); err != nil || len(times) != 0 {
+        t.Errorf("missing ref: got %v, %v, want no notes", times, err)
+    }
+
+    // Noted time wins over the commit's own
+    run := r.run("--dry-run", "--notes-ref", "times")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    want := map[string]string{"a": "2015-05-05T05:05:05Z", "b": "2020-02-01T00:00:00Z", "c": "2020-03-01T00:00:00Z"}
+    got := run.times(t)
+    for f, date := range want {
+        if !got[f].Equal(mustTime(t, date)) {
+            t.Errorf("%v: got %v, want %v", f, got[f], date)
+        }
+    }
+}
+
+func TestParseGitTime(t *testing.T) {
+    want := time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("", -7*3600))
+    tests := []struct {
+        raw     string
+        layouts []string
+        ok      bool
+    }{
+        {"2006-01-02T15:04:05-07:00", nil, true},
+        {"Mon Jan 2 15:04:05 2006 -0700", nil, true},
+        {"2006-01-02 15:04:05 -0700", nil, true},
+        {"Mon, 2 Jan 2006 15:04:05 -0700", nil, true},
+        {"2006-01-02T15:04:05-07:00", []string{time.RFC3339}, true},
+        {"2006-01-02 15:04:05 -0700", []string{"2006-01-02 15:04:05 -0700"}, true},
+        {"Mon Jan 2 15:04:05 2006 -0700", []string{time.RFC3339}, false},
+        {"", nil, false},
+        {"yesterday", nil, false},
+        {"1136239445 -0700", nil, false},
+        {"2006-01-02", nil, false},
+    }
+    for _, tt := range tests {
+        got, err := ParseGitTime(tt.raw, tt.layouts...)
+        switch {
+        case tt.ok && err != nil:
+            t.Errorf("ParseGitTime(%q, %q): %v", tt.raw, tt.layouts, err)
+        case tt.ok && !got.Equal(want):
+            t.Errorf("ParseGitTime(%q, %q) = %v, want %v", tt.raw, tt.layouts, got, want)
+        case !tt.ok && err == nil:
+            t.Errorf("ParseGitTime(%q, %q) = %v, want error", tt.raw, tt.layouts, got)
+        case !tt.ok && !strings.Contains(err.Error(), tt.raw):
+            t.Errorf("ParseGitTime(%q, %q): error lacks the time stamp: %v", tt.raw, tt.layouts, err)
+        }
+    }
+}
+
+func TestParseDateOrder(t *testing.T) {
+    tests := []struct {
+        s    string
+        want []string
+    }{
+        {"author", []string{"author"}},
+        {"commit", []string{"commit"}},
+        {"author,commit", []string{"author", "commit"}},
+        {"commit,author", []string{"commit", "author"}},
+        {"author,author", nil},
+        {"committer", nil},
+        {"", nil},
+    }
+    for _, tt := range tests {
+        got, err := parseDateOrder(tt.s)
+        if !reflect.DeepEqual(got, tt.want) || (tt.want == nil) != (err != nil) {
+            t.Errorf("parseDateOrder(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+        }
+    }
+}
+
+// First sane field in order wins, the last one there if none is.
+func TestPickDate(t *testing.T) {
+    epoch := time.Unix(0, 0)
+    author := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    commit := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+    absurd := time.Now().AddDate(5, 0, 0)
+    tests := []struct {
+        order []string
+        dates map[string]time.Time
+        want  time.Time
+        ok    bool
+    }{
+        {[]string{"author"}, map[string]time.Time{"author": author, "commit": commit}, author, true},
+        {[]string{"commit", "author"}, map[string]time.Time{"author": author, "commit": commit}, commit, true},
+        {[]string{"author", "commit"}, map[string]time.Time{"author": epoch, "commit": commit}, commit, true},
+        {[]string{"author", "commit"}, map[string]time.Time{"author": absurd, "commit": commit}, commit, true},
+        {[]string{"author", "commit"}, map[string]time.Time{"commit": commit}, commit, true},
+        {[]string{"author", "commit"}, map[string]time.Time{"author": epoch, "commit": absurd}, absurd, true},
+        {[]string{"author"}, map[string]time.Time{"author": epoch, "commit": commit}, epoch, true},
+        {[]string{"author"}, map[string]time.Time{"commit": commit}, time.Time{}, false},
+    }
+    saved := gitDateOrder
+    defer func() { gitDateOrder = saved }()
+    for _, tt := range tests {
+        gitDateOrder = tt.order
+        got, ok := pickDate(tt.dates)
+        if !got.Equal(tt.want) || ok != tt.ok {
+            t.Errorf("%v of %v: got %v, %v, want %v, %v", tt.order, tt.dates, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+// Commit with a zero author date gets its committer date with the chain
+// author,commit, both with the default and the batched resolver.
+func TestDateOrderZeroAuthor(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.write("b", "1")
+    r.git("add", "b")
+    r.gitAt("2021-01-01T00:00:00Z", "commit", "-q", "-m", "zero author", "--date=@0 +0000")
+
+    tests := []struct {
+        args []string
+        want string
+    }{
+        {nil, "1970-01-01T00:00:00Z"},
+        {[]string{"--date-order", "author,commit"}, "2021-01-01T00:00:00Z"},
+        {[]string{"--date-order", "author,commit", "--resolver", "catfile"}, "2021-01-01T00:00:00Z"},
+        {[]string{"--date-order", "commit"}, "2021-01-01T00:00:00Z"},
+    }
+    for _, tt := range tests {
+        run := r.run(append([]string{"--dry-run", "--time-format", "rfc3339"}, tt.args...)...)
+        if run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.args, run.Code, run.Stderr)
+        }
+        got := run.times(t)
+        if !got["b"].Equal(mustTime(t, tt.want)) || !got["a"].Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: got %v, want b at %v", tt.args, got, tt.want)
+        }
+    }
+}
+
+// With --graceful-old-format legacy layouts are read where ISO 8601 was
+// asked for, without it they fail.
+func TestGracefulOldFormat(t *testing.T) {
+    want := time.Date(2006, 1, 2, 15, 4, 5, 0, time.FixedZone("", -7*3600))
+    legacy := []string{
+        "Mon Jan 2 15:04:05 2006 -0700",
+        "2006-01-02 15:04:05 -0700",
+        "Mon, 2 Jan 2006 15:04:05 -0700",
+    }
+    saved, savedRunner := gitTimeFallback, gitRunner
+    defer func() { gitTimeFallback, gitRunner = saved, savedRunner }()
+
+    for _, raw := range legacy {
+        gitTimeFallback = false
+        if _, err := ParseGitTime(raw, time.RFC3339); err == nil {
+            t.Errorf("%q read as ISO 8601 without fallback", raw)
+        }
+        gitTimeFallback = true
+        if got, err := ParseGitTime(raw, time.RFC3339); err != nil || !got.Equal(want) {
+            t.Errorf("%q with fallback: got %v, %v", raw, got, err)
+        }
+
+        // As a git wrapper printing its own format would
+        gitRunner = func(stdin []byte, args ...string) ([]byte, []byte, error) {
+            return []byte(raw + "\x00" + raw + "\x00\nfile\x00"), nil, nil
+        }
+        date, files, err := getCommitFiles("HEAD")
+        if err != nil || !date.Equal(want) || len(files) != 1 {
+            t.Errorf("%q from git show: got %v, %v, %v", raw, date, files, err)
+        }
+    }
+
+    gitTimeFallback = true
+    if _, err := ParseGitTime("15:04 yesterday", time.RFC3339); err == nil || !strings.Contains(err.Error(), "15:04 yesterday") {
+        t.Errorf("no layout fits: got %v, want error quoting the time", err)
+    }
+}
+
+// Batches keep every include once, in order, each within the limit and
+// with all exclusions.
+func TestPathspecBatches(t *testing.T) {
+    if got := pathspecBatches([]string{"a", ":!b"}); !reflect.DeepEqual(got, [][]string{{"a", ":!b"}}) {
+        t.Errorf("few pathspecs: got %q", got)
+    }
+
+    var includes []string
+    for i := 0; i < 2000; i++ {
+        includes = append(includes, strings.Repeat("x", 20)+strconv.Itoa(i))
+    }
+    excludes := []string{":!one", ":(exclude)two", ":^three"}
+    batches := pathspecBatches(append(append([]string{}, includes...), excludes...))
+    if len(batches) < 2 {
+        t.Fatalf("got %d batches", len(batches))
+    }
+    var got []string
+    for _, batch := range batches {
+        if !pathspecsFit(batch) {
+            t.Errorf("batch of %d pathspecs doesn't fit", len(batch))
+        }
+        n := len(batch) - len(excludes)
+        if !reflect.DeepEqual(batch[n:], excludes) {
+            t.Errorf("batch ends with %q, want exclusions", batch[n:])
+        }
+        got = append(got, batch[:n]...)
+    }
+    if !reflect.DeepEqual(got, includes) {
+        t.Errorf("includes of batches differ from those given")
+    }
+}
+
+// Text and binary only select files by git's own detection of their
+// content, leaving the others as they are.
+func TestTextBinaryOnly(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"notes.txt": "text\n", "image.bin": "\x00\x01\x02"})
+    now := time.Now().Truncate(time.Second)
+
+    for flag, want := range map[string]string{"--text-only": "notes.txt", "--binary-only": "image.bin"} {
+        r.setMtime("notes.txt", now)
+        r.setMtime("image.bin", now)
+        if run := r.run(flag); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", flag, run.Code, run.Stderr)
+        }
+        for _, f := range []string{"notes.txt", "image.bin"} {
+            retimed := r.mtime(f).Equal(mustTime(t, "2020-01-01T00:00:00Z"))
+            if retimed != (f == want) {
+                t.Errorf("%v: %v retimed %v", flag, f, retimed)
+            }
+        }
+    }
+}
+
+// Content only skips a commit changing mode alone, the file keeps the
+// time its content last changed.
+func TestContentOnlySkipsChmod(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"run.sh": "echo\n", "other": "1"})
+    if err := os.Chmod(r.path("run.sh"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    r.commit("2020-02-01T00:00:00Z", map[string]string{"other": "2"})
+
+    for _, args := range [][]string{nil, {"--content-only"}, {"--content-only", "--stream"}} {
+        want := "2020-01-01T00:00:00Z"
+        if args == nil {
+            want = "2020-02-01T00:00:00Z"
+        }
+        r.setMtime("run.sh", time.Now())
+        if run := r.run(args...); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+        if got := r.mtime("run.sh"); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", args, got, want)
+        }
+    }
+}
+
+// Features are told by the version git prints, as vendors write it. A
+// version that can't be read is taken to have all.
+func TestGitSupports(t *testing.T) {
+    saved := gitVersionSeen
+    t.Cleanup(func() { gitVersionSeen = saved })
+
+    cases := []struct {
+        version, feature string
+        want             bool
+    }{
+        {"2.20.1", "iso-dates", true},
+        {"2.20.1", "commit-graph", true},
+        {"2.17.1", "commit-graph", false},
+        {"2.14.0", "is-shallow", false},
+        {"2.39.2.windows.1", "is-shallow", true},
+        {"1.8.3.1", "iso-dates", false},
+        {"2.39.3 (Apple Git-146)", "commit-graph", true},
+        {"Apple Git-143", "commit-graph", true},
+        {"3.0", "commit-graph", true},
+    }
+    for _, c := range cases {
+        gitVersionSeen = c.version
+        if got := gitSupports(c.feature); got != c.want {
+            t.Errorf("%q has %v: got %v, want %v", c.version, c.feature, got, c.want)
+        }
+    }
+
+    gitVersionSeen = "2.12.5"
+    err := requireGit("absolute-git-dir")
+    if err == nil || !strings.Contains(err.Error(), "requires git >= 2.13, found 2.12.5") {
+        t.Errorf("got %v", err)
+    }
+}
+
+// Warnings git prints on standard error alongside its output don't get
+// into what is parsed.
+func TestGitWarningsNotParsed(t *testing.T) {
+    h := newFakeHistory(30, 10)
+    saved := gitRunner
+    gitRunner = func(stdin []byte, args ...string) (stdout, stderr []byte, err error) {
+        stdout, _, err = h.run(stdin, args...)
+        return stdout, []byte("warning: in the working copy of 'a', LF will be replaced by CRLF\n"), err
+    }
+    t.Cleanup(func() { gitRunner = saved })
+
+    tracked := h.tracked()
+    plan, err := buildPlan(context.Background(), nil, tracked, nil, "log", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(plan) != len(tracked) {
+        t.Fatalf("got %d files, want %d", len(plan), len(tracked))
+    }
+    for _, e := range plan {
+        if want := h.dates[h.newest[e.Path]]; !e.Mtime.Equal(want) {
+            t.Errorf("%v: got %v, want %v", e.Path, e.Mtime, want)
+        }
+    }
+}
+
+// A failing git is reported with its command line, exit status and what
+// it printed on standard error.
+func TestGitError(t *testing.T) {
+    saved := gitRunner
+    gitRunner = func(stdin []byte, args ...string) (stdout, stderr []byte, err error) {
+        err = exec.Command("sh", "-c", "exit 3").Run()
+        return nil, []byte("fatal: bad revision\n"), err
+    }
+    t.Cleanup(func() { gitRunner = saved })
+
+    _, err := runGit("log", "--format=%H", "two words")
+    var gerr *gitError
+    if !errors.As(err, &gerr) || gerr.ExitCode != 3 {
+        t.Fatalf("got %v, want git error with exit status 3", err)
+    }
+    if want := `git log --format=%H "two words": exit status 3: fatal: bad revision`; err.Error() != want {
+        t.Errorf("got %q, want %q", err, want)
+    }
+}
+
+// A commit touching very many files, or a very long path, is read whole,
+// git output isn't scanned by lines.
+func TestHugeCommitFileList(t *testing.T) {
+    h := newFakeHistory(1, 1)
+    long := strings.Repeat("d/", 100000) + "f"
+    h.files[0] = []string{long}
+    for i := 0; i < 50000; i++ {
+        h.files[0] = append(h.files[0], fmt.Sprintf("dir%d/file%d.go", i%100, i))
+    }
+    h.install(t)
+
+    _, fs, err := getCommitFiles(h.hashes[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(fs) != len(h.files[0]) || fs[0] != long || fs[len(fs)-1] != h.files[0][len(fs)-1] {
+        t.Errorf("got %d files, want %d", len(fs), len(h.files[0]))
+    }
+}
+
+// Tracing logs each git command line before it runs and how long it
+// took after.
+func TestTraceGit(t *testing.T) {
+    newFakeHistory(3, 2).install(t)
+    gitTrace = true
+    t.Cleanup(func() { gitTrace = false })
+
+    // Trace goes to standard error
+    read, write, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := os.Stderr
+    os.Stderr = write
+    _, err = runGit("log", "--format=%H")
+    os.Stderr = saved
+    write.Close()
+    if err != nil {
+        t.Fatal(err)
+    }
+    out, _ := io.ReadAll(read)
+
+    lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+    if len(lines) != 2 || lines[0] != "TRACE git log --format=%H" || !strings.HasPrefix(lines[1], "TRACE done in ") {
+        t.Errorf("got %q", out)
+    }
+}
+
+// A git wrapper printing a banner on standard output is reported, and
+// bypassed with --git-path.
+func TestGitWrapperBanner(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    realGit, err := exec.LookPath("git")
+    if err != nil {
+        t.Fatal(err)
+    }
+    bin := t.TempDir()
+    script := "#!/bin/sh\necho 'Welcome to corporate git'\nexec " + shellQuote(realGit) + " \"$@\"\n"
+    if err := os.WriteFile(filepath.Join(bin, "git"), []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+    env := []string{"PATH=" + bin + string(os.PathListSeparator) + os.Getenv("PATH")}
+
+    run := runGitimeEnv(t, r.Dir, "", env)
+    if run.Code != 1 || !strings.Contains(run.Stderr, "a wrapper printing text? use --git-path") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    run = runGitimeEnv(t, r.Dir, "", env, "--git-path", realGit)
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if got := r.mtime("a"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("a got %v", got)
+    }
+}
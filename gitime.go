@@ -1,18 +1,22 @@
 // Update GIT project files mtime to latest file commit.
-// Usage is funky because git not always understands --work-tree.
-// Need to cd to git repo dir first, then run this command:
+// Run this command anywhere inside the git work tree:
 // cd <git-proj-dir> && <this-exec-dir>/gitime
 package main
 
 import (
     "bytes"
+    "context"
     "errors"
+    "flag"
     "fmt"
     "os"
-    "os/exec"
     "path"
     "path/filepath"
+    "sort"
+    "strconv"
     "strings"
+    "sync"
+    "syscall"
     "time"
 )
 
@@ -20,93 +24,2202 @@ import (
 // Update GIT project files mtime to latest file commit
 //------------------------------------------------------------
 
+// Command line options.
+type Options struct {
+    TextOnly      bool          // Retime only files git considers text
+    BinaryOnly    bool          // Retime only files git considers binary
+    AllowShallow  bool          // Run even in a shallow clone
+    Round         time.Duration // Snap times to nearest multiple, 0 keeps them as is
+    MarkerFile    string        // Touch this file to newest time of the plan
+    SummaryJSON   string        // Write run summary to this file
+    ChunkSize     int           // Pause after this many Chtimes calls, 0 never pauses
+    ChunkPause    time.Duration // Length of pause between chunks
+    FromCommit    string        // Give all tracked files time of this commit
+    ArchiveCompat bool          // Give files time git archive would, leaving out export-ignore ones
+    PrintFiles    bool          // Only print files that would be retimed, without times
+    EmitScript    bool          // Print shell script of touch commands instead of applying
+    LineRanges    []lineRange   // Files taking time of last change to these lines
+    ByBlame       bool          // Files taking time of newest commit among their lines
+    MaxRuntime    time.Duration // Stop walking history after this long, 0 never stops
+    WriteGraph    bool          // Write commit-graph before walking history if missing
+    Granularity   string        // Time per file, or per top level component as newest of its files
+    OnConflict    string        // Whether overrides are adjusted by later stages, last-wins, or final, overrides-win
+    Birthtime     string        // Also set creation time on macOS, to first or last commit time
+    PrintNewest   bool          // Only print newest time of files in each directory
+    PrintCommits  bool          // Only print commits giving files their time
+    Depth         int           // Directory levels to group files by for PrintNewest
+    NewerOnly     bool          // Only move times forward, skipping files changed while running
+    RepoJobs      int           // Repositories given as arguments retimed at once
+    FailFast      bool          // Don't start more repositories once one failed
+    Approx        bool          // Time files of last commit from it, all others from its parent
+    NoFuture      bool          // Clamp times in the future to now
+    NoWait        bool          // Fail instead of waiting if another run holds the lock
+    FailMissing   bool          // Fail if tracked files are missing on disk
+    QuietSkips    bool          // Don't print skipped files, still counting them
+    ResumeFile    string        // Record applied files here, skipping those of an interrupted run
+    AlignOrder    bool          // Nudge times forward so they never go back along history
+    Probe         bool          // Only print diagnostics of git, repository and file system
+    ErrorLimit    int           // Print at most this many errors, 0 prints all
+    ByReleaseTag  bool          // Give files time of the first tag shipping their last commit
+    StampFile     string        // Record HEAD after a run, skipping runs at the same HEAD
+    TimeFormat    string        // Layout or preset of times in text output
+    Gitlinks      bool          // Retime submodule directories like files, to time of their gitlink
+    DebugSpread   time.Duration // Give files made up times this far apart in path order, for testing
+    MinPlan       int           // Refuse to apply plans of fewer files, 0 applies any
+    FromTar       string        // Apply times of files in this tar archive, without git
+    StdinPlan     bool          // Apply times of files read from stdin, without git
+    MaxMemory     int64         // Apply while walking history once plan would take more bytes, 0 never does
+    Stream        bool          // Always apply while walking history
+    Confirm       bool          // Ask on the terminal before applying
+    Yes           bool          // Apply without asking despite Confirm
+    Force         bool          // Run even if stamp file records HEAD or plan is too small
+    Index         bool          // Give files with staged changes the index time
+    IndexTime     string        // Time of staged files, RFC3339, now if empty
+    Root          string        // Retime files under this directory instead of work tree
+    DryRun        bool          // Only print what would be done
+    Diff          bool          // In dry run, print current and new time of changing files
+    Verify        bool          // In dry run, only count files with wrong time, failing if any
+    Audit         bool          // Only report files with time on disk inconsistent with history
+    Verbose       bool          // Print plan summary before applying, with verify list files with wrong time
+    ContentOnly   bool          // Ignore commits changing only mode of a file
+    OverrideFile  string        // Read explicit file times from this file
+    Print0        bool          // Print NUL separated time and path records
+    Format        string        // Output format, text, json or template
+    Template      string        // Go text/template rendering each entry with template format
+    ShowCommit    bool          // Print commit that gave each file its time
+    SkipUnchanged bool          // Don't touch files already at their time
+    NoDereference bool          // Retime symlinks themselves, not their targets
+    KeepAtime     bool          // Leave access times as they are
+    AssumePresent bool          // Don't stat files before changing their time
+    UntilFirst    bool          // Stop once one file is retimed, for smoke tests
+    Export        string        // Copy files of HEAD to this directory and retime them there
+    StatJobs      int           // Number of parallel stats before applying
+    Paths         []string      // Retime only files matching these pathspecs
+    Excludes      []string      // Never retime files matching these .gitignore like patterns
+    ExcludeFiles  []string      // Files of exclude patterns, read once in the repository
+    KeepGoing     bool          // Try all files even if tree looks read-only
+    Range         string        // Retime only files changed in this a..b commit range
+    Author        string        // Consider only commits by matching authors
+    NullOnError   bool          // Give tracked files history didn't resolve a sentinel time
+    Sentinel      string        // Sentinel for unresolved files, first-commit or keep
+    OnMissingGit  string        // Policy for a missing or corrupt index, fail or rebuild-index
+    NotesRef      string        // Take commit times from notes in this ref where present
+    DedupeLinks   bool          // Retime hard linked files once per inode
+    PrintEpoch    bool          // Only print newest time of the plan as Unix seconds
+    RespectIgnore bool          // Skip files matching ignore rules, even if tracked
+    SkipAssumed   bool          // Skip files marked assume-unchanged
+    SkipWorktree  bool          // Skip files marked skip-worktree
+    Resolver      string        // How commits are read, log runs git per commit, catfile batches
+    SinceFile     string        // Retime only files with time newer than mtime of this file
+    NewOnly       string        // Retime only files added after this time or commit
+    Dirs          bool          // Also give directories newest time of files under them
+    EmptyDirTime  string        // Time of directories without tracked files, skip, now or parent
+
+    // Maps git path of each file to its path on disk, relative to the
+    // root. Files it gives no path for are skipped. Called for all files
+    // from a single goroutine before any is stat or retimed, so it needs
+    // no locking, but must not hold up other goroutines it shares state
+    // with. Nil maps every path to itself.
+    PathMapper func(gitPath string) (localPath string, ok bool)
+}
+
+// Counts of a run.
+type runStats struct {
+    Applied      int // Files retimed
+    TypeMismatch int // Files skipped as different kind on disk than in git
+    Unchanged    int // Files skipped as already at their time
+    Deduped      int // Hard links skipped as their file was retimed by another name
+    Unmapped     int // Files the path mapper gave no disk path for
+    Dirs         int // Directories retimed
+    NotNewer     int // Files skipped as their time isn't newer than current one
+    Raced        int // Files skipped as changed on disk while running
+    Gitlinks     int // Submodules skipped as not files of this tree
+    Errors       int // Files failed to retime
+    Denied       int // Files failed to retime for permissions
+    Future       int // Files with time in the future
+    Unresolved   int // Tracked files given a sentinel time
+
+    Missing []string            // Tracked files skipped as not existing
+    Failed  map[string][]string // Files failed to retime by error class
+    Oldest  time.Time           // Oldest applied time
+    Newest  time.Time           // Newest applied time
+
+    StoppedEarly bool // Walk ran out of time, not all files resolved
+
+    chunkCalls int // Chtimes calls since the last pause of --chunk-size
+}
+
+// Single file to retime.
+type planEntry struct {
+    Path   string    `json:"path"`
+    Mtime  time.Time `json:"mtime"`
+    Commit string    `json:"commit,omitempty"` // Commit giving the time, empty if overridden
+
+    Unresolved bool `json:"unresolved,omitempty"` // History gave no time, sentinel used
+
+    Created time.Time `json:"-"` // Creation time to set, zero to use mtime
+}
+
 func main() {
-    pwd, err := os.Getwd()
+    opts := Options{PathMapper: identityPath}
+    flag.BoolVar(&opts.TextOnly, "text-only", false, "retime only files git considers text")
+    flag.BoolVar(&opts.BinaryOnly, "binary-only", false, "retime only files git considers binary")
+    flag.BoolVar(&opts.AllowShallow, "allow-shallow", false, "run in a shallow clone despite possibly wrong times")
+    flag.DurationVar(&opts.Round, "round", 0, "round times to nearest multiple of duration, e.g. 2s for ZIP")
+    flag.StringVar(&opts.SummaryJSON, "summary-json", "", "write JSON summary of the run to `file`")
+    flag.StringVar(&opts.MarkerFile, "write-marker", "", "create or touch `file` to the newest time of the plan, for find -newer and make, also with --dry-run")
+    flag.IntVar(&opts.ChunkSize, "chunk-size", 0, "pause after every `N` files retimed, 0 never pauses")
+    flag.DurationVar(&opts.ChunkPause, "chunk-pause", 10*time.Millisecond, "length of pause between chunks")
+    flag.StringVar(&opts.FromCommit, "from-commit", "", "give all tracked files the time of `commit`, ignoring history")
+    flag.BoolVar(&opts.NoFuture, "no-future", false, "clamp times in the future to now")
+    flag.BoolVar(&opts.FailMissing, "fail-missing", false, "fail if tracked files are missing on disk")
+    flag.BoolFunc("ignore-missing", "skip tracked files missing on disk (default)", func(s string) (err error) {
+        ignore, err := strconv.ParseBool(s)
+        opts.FailMissing = !ignore
+        return
+    })
+    flag.Func("max-memory", "apply while walking history instead of holding a plan projected over `bytes`, with K, M or G suffix", func(s string) (err error) {
+        opts.MaxMemory, err = parseBytes(s)
+        return
+    })
+    flag.BoolVar(&opts.ByBlame, "by-blame", false, "give files the time of the newest commit among their lines by git blame, slow")
+    flag.BoolVar(&opts.Confirm, "confirm", false, "show a summary of the plan and ask before applying it, on a terminal, refusing without one")
+    flag.BoolVar(&opts.Yes, "yes", false, "with --confirm, apply without asking")
+    flag.BoolVar(&opts.Stream, "stream", false, "retime files as soon as history resolves them, without planning all first")
+    flag.StringVar(&opts.FromTar, "from-tar", "", "apply times recorded in tar archive `file` to the same paths here, without git")
+    flag.BoolVar(&opts.StdinPlan, "stdin-plan", false, "apply times of files read from stdin as path<TAB>RFC3339-time records, one per line or NUL terminated, without git")
+    flag.IntVar(&opts.MinPlan, "apply-only-if-plan-size-at-least", 0, "refuse to apply and fail if fewer than `N` files would be retimed, guarding against the wrong directory")
+    flag.DurationVar(&opts.DebugSpread, "debug-spread", 0, "for testing, give files made up times `step` apart in path order instead of real ones")
+    flag.BoolVar(&opts.Gitlinks, "include-gitlinks-as-files", false, "retime submodule directories to the last commit changing their gitlink, instead of skipping them")
+    flag.StringVar(&opts.TimeFormat, "time-format", "rfc3339", "`layout` of times in text output, rfc3339, unix, date or a Go time layout")
+    flag.StringVar(&opts.StampFile, "stamp-file", "", "record HEAD in `file` after a run and do nothing while HEAD is the same")
+    flag.BoolVar(&opts.Force, "force", false, "run even if --stamp-file records HEAD or the plan is smaller than --apply-only-if-plan-size-at-least")
+    flag.BoolVar(&opts.ByReleaseTag, "by-release-tag", false, "give files the time of the earliest tag containing their last commit")
+    flag.IntVar(&opts.ErrorLimit, "error-limit", 50, "print at most `n` errors changing times, counting the rest, 0 prints all")
+    flag.BoolVar(&opts.Index, "index", false, "give files with staged changes the --index-time, others keep their committed time")
+    flag.StringVar(&opts.IndexTime, "index-time", "", "RFC3339 `time` of staged files with --index (default now)")
+    flag.BoolVar(&opts.Probe, "probe", false, "print diagnostics of git, the repository and its file system for reporting problems")
+    flag.BoolVar(&opts.AlignOrder, "align-to-commit-order", false, "move times forward so a commit is never older than one before it in history")
+    flag.StringVar(&opts.ResumeFile, "resume-file", "", "record applied files in `file` and skip them when run again at the same HEAD")
+    flag.BoolVar(&opts.QuietSkips, "quiet-skips", false, "don't print SKIP lines of files left alone, they are still counted")
+    flag.StringVar(&opts.Root, "root", "", "retime files under `dir` instead of the git work tree")
+    flag.BoolVar(&opts.DryRun, "dry-run", false, "print times without changing any file")
+    flag.BoolVar(&opts.Diff, "diff", false, "with --dry-run, print current and new time of files that would change")
+    flag.BoolVar(&opts.Verify, "verify", false, "with --dry-run, print count of files with wrong time and fail if any")
+    flag.BoolVar(&opts.Audit, "audit", false, "change nothing, report files with time on disk far in the future or before they were added, and fail if any")
+    flag.BoolVar(&opts.Verbose, "verbose", false, "print summary of the plan before applying, with --verify also list files with wrong time")
+    flag.BoolVar(&opts.ContentOnly, "content-only", false, "take time of last content change, ignoring commits changing only file mode")
+    flag.StringVar(&opts.OverrideFile, "override-file", "", "read explicit times of files from `file`, winning over history")
+    flag.BoolVar(&opts.Print0, "print0", false, "print NUL separated time and path records, for xargs -0")
+    flag.StringVar(&opts.Format, "format", "text", "output `format`, text, json (one object per line) or template")
+    flag.StringVar(&opts.Template, "template", "", "with --format template, Go `template` for each entry, with .Path, .Mtime, .Commit, .Applied and .Error")
+    flag.BoolVar(&opts.ShowCommit, "show-commit", false, "print the commit that gave each file its time")
+    flag.BoolVar(&opts.SkipUnchanged, "skip-unchanged", false, "don't touch files already at their time")
+    flag.BoolVar(&opts.NoDereference, "no-dereference", false, "retime symlinks themselves instead of their targets, Linux only")
+    flag.StringVar(&opts.Export, "export", "", "copy files of HEAD to `dir` like git archive, honoring export-ignore and --path, and retime them there")
+    flag.BoolVar(&opts.UntilFirst, "until-first-applied", false, "for smoke tests, stop once one file is retimed, not for production use")
+    flag.BoolVar(&opts.AssumePresent, "assume-present", false, "don't stat files before retiming them, for complete checkouts, missing ones are still reported")
+    flag.BoolVar(&opts.KeepAtime, "keep-atime", false, "leave access times as they are, only modification times change")
+    flag.BoolFunc("minimal", "least metadata churn, same as --skip-unchanged --no-dereference --keep-atime", func(s string) error {
+        opts.SkipUnchanged, opts.NoDereference, opts.KeepAtime = true, true, true
+        return nil
+    })
+    flag.IntVar(&opts.StatJobs, "stat-jobs", 1, "stat `N` files in parallel before applying, helps on network file systems, 0 or 1 stats one at a time")
+    flag.Func("path", "retime only files matching `pathspec`, may be repeated", func(s string) error {
+        opts.Paths = append(opts.Paths, s)
+        return nil
+    })
+    flag.Func("line-range", "give `file:start-end` the time of the last change to those lines, may be repeated", func(s string) error {
+        lr, err := parseLineRange(s)
+        opts.LineRanges = append(opts.LineRanges, lr)
+        return err
+    })
+    flag.Func("exclude", "never retime files matching .gitignore like `pattern`, may be repeated", func(s string) error {
+        opts.Excludes = append(opts.Excludes, s)
+        return checkExclude(s)
+    })
+    flag.Func("exclude-from", "read exclude patterns from `file`, one per line, may be repeated", func(s string) error {
+        opts.ExcludeFiles = append(opts.ExcludeFiles, s)
+        return nil
+    })
+    flag.BoolVar(&opts.KeepGoing, "keep-going", false, "try all files even if the work tree looks read-only")
+    flag.StringVar(&opts.Range, "range", "", "retime only files changed in `a..b`, to their newest commit in it")
+    flag.StringVar(&opts.Author, "author", "", "consider only commits by authors matching `pattern`, after .mailmap")
+    flag.BoolVar(&opts.NullOnError, "null-on-error", false, "give tracked files history didn't resolve a sentinel time and flag them")
+    flag.StringVar(&opts.Sentinel, "sentinel", "first-commit", "sentinel `kind` for unresolved files, first-commit time or keep current mtime")
+    flag.StringVar(&opts.OnMissingGit, "on-missing-git", "fail", "`policy` for a missing or corrupt git index while HEAD has files, fail with exit 4 or rebuild-index from HEAD")
+    flag.StringVar(&opts.NotesRef, "notes-ref", "", "take commit times from RFC3339 git notes in `ref` where present")
+    flag.BoolVar(&opts.DedupeLinks, "dedupe-hardlinks", false, "retime hard linked files once, to the newest time of their names")
+    flag.BoolVar(&opts.PrintEpoch, "print-epoch", false, "only print newest time of all files as Unix seconds, for SOURCE_DATE_EPOCH")
+    flag.BoolVar(&opts.RespectIgnore, "respect-gitignore", false, "skip files matching .gitignore rules, even if tracked")
+    flag.BoolVar(&opts.SkipAssumed, "skip-assume-unchanged", false, "skip files marked assume-unchanged with git update-index")
+    flag.BoolVar(&opts.SkipWorktree, "skip-worktree", false, "skip files marked skip-worktree with git update-index")
+    flag.StringVar(&opts.Resolver, "resolver", "log", "how commits are read, `resolver` log (git per commit) or catfile (batched)")
+    flag.StringVar(&opts.SinceFile, "since-file", "", "retime only files with time newer than mtime of `file`, like make")
+    flag.StringVar(&opts.NewOnly, "touch-new-only", "", "retime only files added after `baseline`, an RFC3339 time or a commit")
+    flag.BoolVar(&opts.NoWait, "no-wait", false, "fail at once if another gitime is retiming this tree, instead of waiting")
+    flag.BoolVar(&opts.Dirs, "dirs", false, "also give directories the newest time of tracked files under them")
+    flag.StringVar(&opts.EmptyDirTime, "empty-dir-time", "skip", "with --dirs, `policy` for directories without tracked files, skip, now or parent")
+    flag.BoolVar(&opts.ArchiveCompat, "archive-compat", false, "give files the times git archive would, that of HEAD or --from-commit, skipping export-ignore")
+    flag.BoolVar(&opts.EmitScript, "emit-script", false, "print a shell script of touch commands setting the times, instead of applying")
+    flag.BoolVar(&opts.PrintFiles, "print-files", false, "only print tracked files that would be retimed, without times")
+    flag.DurationVar(&opts.MaxRuntime, "max-runtime", 0, "stop walking history after `duration`, retime files resolved so far and exit 3")
+    flag.BoolVar(&opts.WriteGraph, "write-commit-graph", false, "write git commit-graph before walking history if missing, speeding up the walk")
+    flag.StringVar(&opts.Granularity, "granularity", "file", "`level` of times, file or component (newest of each top level directory for all its files)")
+    flag.StringVar(&opts.OnConflict, "on-conflict", "last-wins", "`policy` for override times, last-wins lets clamp, rounding and granularity adjust them, overrides-win keeps them exact")
+    flag.StringVar(&opts.Birthtime, "set-birthtime", "", "on macOS also set creation time, to time of `commit` first adding the file or last changing it (first or last)")
+    flag.BoolVar(&opts.PrintNewest, "print-newest-per-dir", false, "only print each directory with the newest time of files in it")
+    flag.BoolVar(&opts.PrintCommits, "print-commits", false, "only print commits giving at least one file its time, with their time and number of files")
+    flag.IntVar(&opts.Depth, "depth", 1, "with --print-newest-per-dir, group files by `N` levels of directories")
+    flag.BoolVar(&opts.NewerOnly, "newer-only", false, "only retime files to a time newer than their current one, skipping files changed while running")
+    flag.IntVar(&opts.RepoJobs, "repo-jobs", 1, "with several repositories as arguments, retime `N` at once, 0 or 1 one at a time streaming output")
+    flag.BoolVar(&opts.FailFast, "fail-fast", false, "with several repositories, start no more once one failed")
+    flag.BoolVar(&opts.Approx, "approx", false, "fast approximate times without walking history: files of HEAD get its time, all others that of its parent, not their own")
+    flag.StringVar(&gitExecutable, "git-path", "git", "run git at `path`, e.g. to bypass a wrapper script in PATH")
+    flag.Func("date-order", "commit time `fields` of history tried in order, first sane one wins, e.g. author,commit (default author)", func(s string) (err error) {
+        gitDateOrder, err = parseDateOrder(s)
+        return
+    })
+    flag.BoolVar(&gitTimeFallback, "graceful-old-format", false, "if a time git prints isn't ISO 8601, try its legacy and RFC 2822 formats")
+    flag.BoolVar(&gitTrace, "trace-git", false, "log every git command run and its duration to stderr")
+    flag.Usage = usage
+    flag.Parse()
+
+    // Repositories as arguments, working directory if none. Single one
+    // is entered first so relative paths in options are taken from it,
+    // as they are for each of several
+    repos := flag.Args()
+    if len(repos) == 1 {
+        if err := os.Chdir(repos[0]); err != nil {
+            fmt.Fprintf(os.Stderr, "Error entering repository: %v\n", err)
+            os.Exit(1)
+        }
+    }
+
+    // Several repositories each read them in their own run
+    if len(repos) <= 1 {
+        for _, fpath := range opts.ExcludeFiles {
+            patterns, err := loadExcludes(fpath)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error reading exclude patterns: %v\n", err)
+                os.Exit(2)
+            }
+            opts.Excludes = append(opts.Excludes, patterns...)
+        }
+    }
+
+    if err := opts.validate(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
+    }
+    if opts.ArchiveCompat && opts.FromCommit == "" {
+        opts.FromCommit = "HEAD"
+    }
+    if opts.EmitScript || opts.Audit {
+        opts.DryRun = true
+    }
+
+    // Times of an archive or another tool need no repository
+    if opts.FromTar != "" {
+        os.Exit(runTar(opts))
+    }
+    if opts.StdinPlan {
+        os.Exit(runStdinPlan(opts))
+    }
+
+    // Support triage, works outside of repositories too
+    if opts.Probe {
+        probe()
+        os.Exit(0)
+    }
+
+    if len(repos) > 1 {
+        if opts.Export != "" {
+            fmt.Fprintln(os.Stderr, "Option --export takes a single repository")
+            os.Exit(2)
+        }
+        args := os.Args[1 : len(os.Args)-len(repos)]
+        os.Exit(runRepos(repos, args, opts.RepoJobs, opts.FailFast))
+    }
+
+    // Git paths are relative to the top of the work tree, which with
+    // core.worktree set needn't be where .git is
+    workTree, err := gitTopLevel()
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error getting working directory: %v", err)
-        fmt.Println("Usage:")
-        fmt.Println("cd <git-dir> && <bin-dir>/gitime")
+        fmt.Fprintf(os.Stderr, "Error finding git work tree: %v\n", err)
+        usage()
         os.Exit(1)
     }
 
-    logWalk(pwd)
+    // Git lists files relative to where it runs, run it at the top
+    gitWorkTree = workTree
+
+    // Parallel runs would race on the same files, only reading needs no lock
+    if !opts.DryRun && !opts.PrintEpoch && !opts.PrintFiles && !opts.PrintNewest && !opts.PrintCommits {
+        gitDir, err := gitAbsoluteDir()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error finding git directory: %v\n", err)
+            os.Exit(1)
+        }
+        if err = acquireLock(gitDir, !opts.NoWait); err != nil {
+            fmt.Fprintf(os.Stderr, "Error locking work tree: %v\n", err)
+            os.Exit(1)
+        }
+    }
+
+    logWalk(workTree, opts)
+    releaseLock()
+}
+
+// Maps git path to the same path on disk.
+func identityPath(gitPath string) (localPath string, ok bool) {
+    return gitPath, true
+}
+
+// Prints usage.
+func usage() {
+    fmt.Println("Usage:")
+    fmt.Println("cd <git-work-tree> && <bin-dir>/gitime [options]")
+    fmt.Println("<bin-dir>/gitime [options] <git-work-tree>...")
+    fmt.Println("Options:")
+    flag.PrintDefaults()
+}
+
+// Get full commit list and files updated at each commit,
+// then apply the newest commit time to each file.
+func logWalk(workTree string, opts Options) {
+    start := time.Now()
+    if err := opts.validate(); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        exit(2)
+    }
+    if opts.Format == "template" {
+        entryTemplate, _ = parseEntryTemplate(opts.Template)
+    }
+
+    // Times are read in a format old git doesn't print
+    if err := requireGit("iso-dates"); err != nil {
+        fmt.Fprintf(os.Stderr, "Error %v\n", err)
+        exit(1)
+    }
+
+    // Fresh repository or orphan branch, files may be staged but nothing
+    // is committed yet
+    if _, err := gitResolveCommit("HEAD"); err != nil {
+        fmt.Fprintln(os.Stderr, "No commits yet on this branch to take times from, nothing to do; commit files first")
+        exit(0)
+    }
+
+    // Repeated runs at one HEAD do nothing, not even reading history
+    var head string
+    if opts.StampFile != "" {
+        var err error
+        if head, err = gitHead(); err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading HEAD: %v\n", err)
+            exit(1)
+        }
+        current, err := stampCurrent(opts.StampFile, head)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading stamp file: %v\n", err)
+            exit(1)
+        }
+        if current && !opts.Force {
+            fmt.Fprintf(os.Stderr, "Already retimed at HEAD %v, nothing to do; use --force to run anyway\n", shortHash(head))
+            exit(0)
+        }
+    }
+
+    // Files at HEAD and their kinds, to not retime a directory for a file
+    modes, err := gitTreeModes()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error listing git tree: %v\n", err)
+        exit(1)
+    }
+    checkIndex(modes, opts)
+
+    if opts.PrintFiles {
+        printFiles(modes, opts)
+        return
+    }
+
+    if opts.WriteGraph && opts.FromCommit == "" {
+        ensureCommitGraph()
+    }
+
+    // Walk stops when out of time, applying what it has
+    ctx := context.Background()
+    if opts.MaxRuntime > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, opts.MaxRuntime)
+        defer cancel()
+    }
+
+    var plan []planEntry
+    var unresolved int
+    var stoppedEarly bool
+    if opts.FromCommit != "" {
+        // History is not needed for a single commit
+        plan, err = commitPlan(opts.FromCommit, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error resolving commit %v: %v\n", opts.FromCommit, err)
+            exit(1)
+        }
+    } else if opts.Approx {
+        plan, err = approxPlan(planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error resolving HEAD: %v\n", err)
+            exit(1)
+        }
+    } else if opts.Range != "" {
+        checkShallow(opts)
+        from, to, _ := strings.Cut(opts.Range, "..")
+        plan, err = rangePlan(ctx, from, to, commitFilter(opts), planPathspecs(opts), opts.Resolver, opts.ContentOnly)
+        stoppedEarly = errors.Is(err, context.DeadlineExceeded)
+        if err != nil && !stoppedEarly {
+            fmt.Fprintf(os.Stderr, "Error resolving range %v: %v\n", opts.Range, err)
+            exit(1)
+        }
+    } else {
+        checkShallow(opts)
+        tracked, err := trackedFiles(modes, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error listing git files: %v\n", err)
+            exit(1)
+        }
+
+        // Huge trees are applied as history is walked, not held whole
+        if opts.Stream {
+            streamRun(ctx, planRoot(workTree, opts), tracked, modes, head, opts, start)
+            return
+        }
+        if opts.MaxMemory > 0 && planBytes(tracked) > opts.MaxMemory {
+            if blocker := streamBlocker(opts); blocker != "" {
+                fmt.Fprintf(os.Stderr, "WARNING plan exceeds --max-memory, but %v needs it whole, keeping it in memory\n", blocker)
+            } else {
+                fmt.Fprintln(os.Stderr, "Plan exceeds --max-memory, applying while walking history")
+                streamRun(ctx, planRoot(workTree, opts), tracked, modes, head, opts, start)
+                return
+            }
+        }
+        plan, err = buildPlan(ctx, commitFilter(opts), tracked, planPathspecs(opts), opts.Resolver, opts.ContentOnly)
+        stoppedEarly = errors.Is(err, context.DeadlineExceeded)
+        if err != nil && !stoppedEarly {
+            fmt.Fprintf(os.Stderr, "Error listing git commits: %v", err)
+            exit(1)
+        }
+
+        // Account for every tracked file, even those history missed.
+        // Not when out of time, history would have resolved them
+        if opts.NullOnError && !stoppedEarly {
+            plan, unresolved, err = addUnresolved(plan, tracked, opts.Sentinel)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error resolving sentinel time: %v\n", err)
+                exit(1)
+            }
+            if unresolved > 0 {
+                fmt.Fprintf(os.Stderr, "Unresolved %d tracked files, sentinel %v\n", unresolved, opts.Sentinel)
+            }
+        }
+    }
+
+    if stoppedEarly {
+        fmt.Fprintf(os.Stderr, "WARNING out of --max-runtime %v, applying %d files resolved so far\n", opts.MaxRuntime, len(plan))
+    }
+
+    // Notes stand in for commit times
+    if opts.NotesRef != "" {
+        notes, err := gitNoteTimes(opts.NotesRef)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading git notes: %v\n", err)
+            exit(1)
+        }
+        count := applyNotes(plan, notes)
+        fmt.Fprintf(os.Stderr, "Noted times of %d files from %d commit notes\n", count, len(notes))
+    }
+
+    // Content is as old as the release first shipping it
+    if opts.ByReleaseTag {
+        count, unreleased, err := applyReleaseTags(plan)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading release tags: %v\n", err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Release times of %d files, %d unreleased keep commit time\n", count, unreleased)
+    }
+
+    // Staged content is newer than any commit
+    if opts.Index {
+        staged, err := gitStagedFiles(planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error listing staged files: %v\n", err)
+            exit(1)
+        }
+        t := start.Round(0)
+        if opts.IndexTime != "" {
+            t, _ = time.Parse(time.RFC3339, opts.IndexTime)
+        }
+        plan = applyStaged(plan, staged, t)
+        fmt.Fprintf(os.Stderr, "Staged files given index time: %d\n", len(staged))
+    }
+
+    // Skewed clocks of committers are evened out
+    if opts.AlignOrder {
+        count, err := alignPlan(plan)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error ordering commits: %v\n", err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Aligned to commit order: %d files\n", count)
+    }
+
+    plan = filterPlan(plan, opts)
+
+    // Content surviving at HEAD, not deletions, makes a file new
+    if opts.ByBlame {
+        fmt.Fprintf(os.Stderr, "WARNING --by-blame runs git blame on each of %d files, this takes a while\n", len(plan))
+        count, kept := applyBlame(plan)
+        fmt.Fprintf(os.Stderr, "Blame gave times of %d files\n", count)
+        if kept > 0 {
+            fmt.Fprintf(os.Stderr, "Not blamed, kept time of history: %d\n", kept)
+        }
+    }
+
+    // Some files are only as new as part of them
+    if len(opts.LineRanges) > 0 {
+        count, err := applyLineRanges(plan, opts.LineRanges)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error resolving line range %v\n", err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Line ranges gave times of %d files\n", count)
+    }
+
+    // Stages adjusting times run in fixed order: overrides, clamp,
+    // rounding, granularity. Overrides replace history times, later
+    // stages then adjust them like any other, unless overrides win and
+    // are applied after all
+    var overrides []override
+    if opts.OverrideFile != "" {
+        overrides, err = loadOverrides(opts.OverrideFile)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading override file: %v\n", err)
+            exit(1)
+        }
+    }
+    if opts.OverrideFile != "" && opts.OnConflict == "last-wins" {
+        count := applyOverrides(plan, overrides)
+        fmt.Fprintf(os.Stderr, "Overridden times of %d files\n", count)
+    }
+
+    // Skewed clocks make commits from the future, make would rebuild forever
+    future := checkFuture(plan, start.Round(0), opts.NoFuture)
+    if future > 0 {
+        if opts.NoFuture {
+            fmt.Fprintf(os.Stderr, "WARNING %d files have commit time in the future, clamped to now\n", future)
+        } else {
+            fmt.Fprintf(os.Stderr, "WARNING %d files have commit time in the future, use --no-future to clamp to now\n", future)
+        }
+    }
+
+    if opts.Round > 0 {
+        roundPlan(plan, opts.Round)
+    }
+
+    // Coarse times for release tarballs
+    if opts.Granularity == "component" {
+        componentPlan(plan, modes)
+    }
+
+    if opts.OverrideFile != "" && opts.OnConflict == "overrides-win" {
+        count := applyOverrides(plan, overrides)
+        fmt.Fprintf(os.Stderr, "Overridden times of %d files\n", count)
+    }
+
+    // Made up times show what was touched and in which order
+    if opts.DebugSpread > 0 {
+        spreadPlan(plan, debugSpreadBase, opts.DebugSpread)
+        fmt.Fprintf(os.Stderr, "WARNING --debug-spread gives made up times %v apart from %v, not times of history\n",
+            opts.DebugSpread, debugSpreadBase.Format(time.RFC3339))
+    }
+
+    // Creation time is a macOS thing
+    if opts.Birthtime != "" && !birthtimeSupported {
+        fmt.Fprintln(os.Stderr, "WARNING --set-birthtime is only supported on macOS, ignored")
+        opts.Birthtime = ""
+    }
+    if opts.Birthtime == "first" {
+        added, err := gitAddedTimes(nil, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error finding times files were added: %v\n", err)
+            exit(1)
+        }
+        for i := range plan {
+            plan[i].Created = added[plan[i].Path]
+        }
+    }
+
+    // Incremental repair up from last build marker
+    if opts.SinceFile != "" {
+        fi, err := os.Stat(opts.SinceFile)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading reference file: %v\n", err)
+            exit(1)
+        }
+        var older int
+        plan, older = filterSince(plan, fi.ModTime())
+        fmt.Fprintf(os.Stderr, "Not newer than %v skipped: %d\n", opts.SinceFile, older)
+    }
+
+    // Repair of files an archive extracted over a checkout got wrong
+    if opts.NewOnly != "" {
+        var older int
+        plan, older, err = filterAdded(plan, opts.NewOnly, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error finding files added after %v: %v\n", opts.NewOnly, err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Added before %v skipped: %d\n", opts.NewOnly, older)
+    }
+
+    // Reproducible builds take newest time as SOURCE_DATE_EPOCH
+    if opts.PrintEpoch {
+        if len(plan) == 0 {
+            fmt.Fprintln(os.Stderr, "Error no files to take newest time from")
+            exit(1)
+        }
+        fmt.Println(newestTime(plan).Unix())
+        return
+    }
+
+    // Freshness overview of the tree
+    if opts.PrintNewest {
+        for _, e := range newestPerDir(plan, modes, opts.Depth) {
+            printEntry(e, opts)
+        }
+        return
+    }
+
+    // Which commits the plan stands on, a future dated one shows on top
+    if opts.PrintCommits {
+        commits, other := planCommits(plan)
+        for _, c := range commits {
+            printCommit(c, opts)
+        }
+        if other > 0 {
+            fmt.Fprintf(os.Stderr, "Files not timed by a commit: %d\n", other)
+        }
+        return
+    }
+
+    // Copies are retimed, not the work tree
+    if opts.Export != "" {
+        files, err := exportTree(opts.Export, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error exporting files: %v\n", err)
+            exit(1)
+        }
+        plan = filterExported(plan, files)
+        fmt.Fprintf(os.Stderr, "Exported files: %d\n", len(files))
+        opts.Root = opts.Export
+    }
+
+    root := planRoot(workTree, opts)
+
+    // Names of files renamed in case alone may be the same file here
+    var collisions [][]string
+    plan, collisions = mergeCaseCollisions(root, plan, modes)
+    if len(collisions) > 0 {
+        fmt.Fprintf(os.Stderr, "Merged paths differing only in case: %d\n", len(collisions))
+    }
+    if opts.Verbose {
+        printCaseCollisions(collisions)
+    }
+
+    // Tiny plan is likely the wrong directory
+    if len(plan) < opts.MinPlan && !opts.DryRun && !opts.Force {
+        fmt.Fprintf(os.Stderr, "Refusing to apply, plan has %d files, fewer than --apply-only-if-plan-size-at-least %d; use --force to apply anyway\n", len(plan), opts.MinPlan)
+        exit(1)
+    }
+
+    // Files an interrupted run applied are done
+    if opts.ResumeFile != "" {
+        head, err := gitHead()
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading HEAD: %v\n", err)
+            exit(1)
+        }
+        done, err := openResume(opts.ResumeFile, head)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error opening resume file: %v\n", err)
+            exit(1)
+        }
+        var count int
+        plan, count = filterResumed(plan, done)
+        fmt.Fprintf(os.Stderr, "Applied by earlier run skipped: %d\n", count)
+    }
+
+    // Checkpoint between resolving and touching any file
+    if opts.Verbose {
+        printPlanSummary(plan)
+    }
+
+    // Check only, the count is the result
+    if opts.Verify {
+        if verifyPlan(root, plan, modes, opts) > 0 {
+            exit(1)
+        }
+        return
+    }
+    if opts.Audit {
+        if auditPlan(root, plan, modes, opts) > 0 {
+            exit(1)
+        }
+        return
+    }
+
+    // Script runs are reviewed and run elsewhere, from any directory
+    if opts.EmitScript {
+        abs, err := filepath.Abs(root)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error finding root directory: %v\n", err)
+            exit(1)
+        }
+        printScriptHeader(abs)
+    }
+
+    // Last chance to back out of the wrong directory
+    if opts.Confirm && !confirmApply(plan, opts) {
+        fmt.Fprintln(os.Stderr, "Not applied")
+        exit(1)
+    }
+
+    stats, applyErr := applyPlan(root, plan, modes, opts)
+    if opts.Dirs && applyErr == nil {
+        if err = applyDirs(root, plan, modes, opts, start.Round(0), &stats); err != nil {
+            fmt.Fprintf(os.Stderr, "Error finding directories: %v\n", err)
+            exit(1)
+        }
+        if !opts.DryRun {
+            fmt.Fprintf(os.Stderr, "Directories retimed: %d\n", stats.Dirs)
+        }
+    }
+    // Explicitly asked for, so written even on a dry run
+    if opts.MarkerFile != "" && applyErr == nil {
+        if len(plan) == 0 {
+            fmt.Fprintln(os.Stderr, "WARNING no files to take newest time from, marker file left alone")
+        } else if err := writeMarker(opts.MarkerFile, newestTime(plan)); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing marker file: %v\n", err)
+            exit(1)
+        }
+    }
+    stats.Future = future
+    stats.Unresolved = unresolved
+    stats.StoppedEarly = stoppedEarly
+    finishRun(stats, len(plan), applyErr, head, opts, start)
+}
+
+// Exit status of a run with all files of the plan missing on disk.
+const exitAllMissing = 5
+
+// Reports counts of a run and exits with its status if it failed.
+// Once complete, resume file is removed and stamp file written.
+func finishRun(stats runStats, files int, applyErr error, head string, opts Options, start time.Time) {
+    if stats.TypeMismatch > 0 {
+        fmt.Fprintf(os.Stderr, "Type-mismatch skipped: %d\n", stats.TypeMismatch)
+    }
+    if stats.Deduped > 0 {
+        fmt.Fprintf(os.Stderr, "Hard links deduped: %d\n", stats.Deduped)
+    }
+    if stats.NotNewer > 0 {
+        fmt.Fprintf(os.Stderr, "Not newer skipped: %d\n", stats.NotNewer)
+    }
+    if stats.Raced > 0 {
+        fmt.Fprintf(os.Stderr, "Changed while running skipped: %d\n", stats.Raced)
+    }
+    if stats.Gitlinks > 0 {
+        fmt.Fprintf(os.Stderr, "Submodules skipped: %d\n", stats.Gitlinks)
+    }
+    if stats.Unmapped > 0 {
+        fmt.Fprintf(os.Stderr, "Unmapped skipped: %d\n", stats.Unmapped)
+    }
+    if opts.AssumePresent && len(stats.Missing) > 0 {
+        fmt.Fprintf(os.Stderr, "WARNING --assume-present but %d files were missing\n", len(stats.Missing))
+    }
+
+    // Report is written even if applying failed
+    if opts.SummaryJSON != "" {
+        err := writeSummary(opts.SummaryJSON, files, stats, time.Since(start))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing summary: %v\n", err)
+            exit(1)
+        }
+    }
+
+    if hidden := stats.Errors - opts.ErrorLimit; opts.ErrorLimit > 0 && hidden > 0 {
+        fmt.Fprintf(os.Stderr, "... and %d more errors\n", hidden)
+    }
+    if applyErr != nil {
+        fmt.Fprintf(os.Stderr, "Stopped, %v\n", applyErr)
+        exit(1)
+    }
+    if stats.Errors > 0 {
+        fmt.Fprintf(os.Stderr, "Error changing mtime of %d files\n", stats.Errors)
+        printErrorClasses(stats)
+        exit(1)
+    }
+
+    if opts.FailMissing && len(stats.Missing) > 0 {
+        fmt.Fprintf(os.Stderr, "Error %d tracked files missing on disk:\n", len(stats.Missing))
+        for _, f := range stats.Missing {
+            fmt.Fprintln(os.Stderr, f)
+        }
+        exit(1)
+    }
+
+    // Nothing to do looks the same, unless told apart
+    if files > 0 && len(stats.Missing) == files {
+        fmt.Fprintf(os.Stderr, "WARNING all %d tracked files are missing from the working tree, nothing was retimed\n", files)
+        fmt.Fprintln(os.Stderr, "Restore them with 'git checkout -- .', or check gitime runs in the right directory or with the right --root")
+        exit(exitAllMissing)
+    }
+
+    if stats.StoppedEarly {
+        fmt.Fprintf(os.Stderr, "Stopped early, only %d files resolved within --max-runtime were retimed\n", files)
+        exit(3)
+    }
+
+    // Nothing is left to resume
+    if opts.ResumeFile != "" {
+        finishResume(opts.ResumeFile)
+    }
+    if opts.StampFile != "" {
+        if err := writeStamp(opts.StampFile, head); err != nil {
+            fmt.Fprintf(os.Stderr, "Error writing stamp file: %v\n", err)
+            exit(1)
+        }
+    }
+}
+
+// Returns directory files are retimed under.
+func planRoot(workTree string, opts Options) string {
+    // Git paths may be staged elsewhere
+    root := workTree
+    if opts.Root != "" {
+        root = opts.Root
+    }
+
+    // Files are joined to the real root, a symlink above it can't send
+    // them somewhere else half way through. Leaf symlinks are left as is
+    resolved, err := filepath.EvalSymlinks(root)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error resolving root directory: %v\n", err)
+        exit(1)
+    }
+    return resolved
+}
+
+// Drops files of the plan the options leave out, reporting how many.
+func filterPlan(plan []planEntry, opts Options) []planEntry {
+    var err error
+
+    // Git skips excluded files already, this guards against its
+    // pathspecs and gitime's own matching ever disagreeing
+    plan, _ = filterExcluded(plan, opts.Excludes)
+
+    // Archives leave out export-ignore files
+    if opts.ArchiveCompat {
+        var skipped int
+        plan, skipped, err = filterExportIgnored(plan)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading export-ignore attributes: %v\n", err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Export-ignore skipped: %d\n", skipped)
+    }
+
+    // Keep only requested class of files
+    if opts.TextOnly || opts.BinaryOnly {
+        plan, err = filterByClass(plan, opts.BinaryOnly)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error classifying git files: %v\n", err)
+            exit(1)
+        }
+    }
+
+    if opts.RespectIgnore {
+        var ignored int
+        plan, ignored, err = filterIgnored(plan)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error checking ignored files: %v\n", err)
+            exit(1)
+        }
+        fmt.Fprintf(os.Stderr, "Ignored %d files matching ignore rules\n", ignored)
+    }
+
+    // Files told to git to be left alone locally
+    if opts.SkipAssumed || opts.SkipWorktree {
+        var assumed, worktree int
+        plan, assumed, worktree, err = filterLocalFlags(plan, opts.SkipAssumed, opts.SkipWorktree)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading index flags: %v\n", err)
+            exit(1)
+        }
+        if opts.SkipAssumed {
+            fmt.Fprintf(os.Stderr, "Assume-unchanged skipped: %d\n", assumed)
+        }
+        if opts.SkipWorktree {
+            fmt.Fprintf(os.Stderr, "Skip-worktree skipped: %d\n", worktree)
+        }
+    }
+    return plan
+}
+
+// Prints files the options select, as --from-commit or HEAD has them,
+// without resolving times.
+func printFiles(modes map[string]string, opts Options) {
+    var plan []planEntry
+    if opts.FromCommit != "" {
+        var err error
+        plan, err = commitPlan(opts.FromCommit, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error resolving commit %v: %v\n", opts.FromCommit, err)
+            exit(1)
+        }
+    } else {
+        tracked, err := trackedFiles(modes, planPathspecs(opts))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error listing git files: %v\n", err)
+            exit(1)
+        }
+        for f := range tracked {
+            plan = append(plan, planEntry{Path: f})
+        }
+    }
+
+    plan = filterPlan(plan, opts)
+    sort.Slice(plan, func(i, j int) bool { return plan[i].Path < plan[j].Path })
+    for _, e := range plan {
+        printPath(e.Path, opts)
+    }
+}
+
+// Writes commit-graph unless repository has one, history walks use it to
+// skip parsing commits. Git too old for it is only warned about.
+func ensureCommitGraph() {
+    if !gitSupports("commit-graph") {
+        fmt.Fprintln(os.Stderr, "WARNING git older than 2.18 has no commit-graph, walking history without")
+        return
+    }
+
+    has, err := gitHasCommitGraph()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error looking for commit-graph: %v\n", err)
+        exit(1)
+    }
+    if has {
+        return
+    }
+    fmt.Fprintln(os.Stderr, "Writing commit-graph")
+    if err = gitWriteCommitGraph(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing commit-graph: %v\n", err)
+        exit(1)
+    }
+}
+
+// Stops if repository is a shallow clone, unless allowed.
+// Shallow history ends at the graft, times of older files are wrong.
+func checkShallow(opts Options) {
+    shallow, err := gitIsShallow()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error checking for shallow clone: %v\n", err)
+        exit(1)
+    }
+    if shallow {
+        fmt.Fprintln(os.Stderr, "WARNING shallow clone, files last changed before the shallow boundary get its time")
+        if !opts.AllowShallow {
+            fmt.Fprintln(os.Stderr, "Refusing to run, fetch full history (git fetch --unshallow) or use --allow-shallow")
+            exit(1)
+        }
+    }
+}
+
+// Gives every tracked file the committer time of one commit.
+func commitPlan(rev string, pathspecs []string) (plan []planEntry, err error) {
+    hash, mtime, err := gitCommitDate(rev)
+    if err != nil {
+        return
+    }
+
+    gitDir, err := gitAbsoluteDir()
+    if err != nil {
+        return
+    }
+    fs, err := gitListFiles(gitDir, pathspecs...)
+    if err != nil {
+        return
+    }
+
+    for _, f := range fs {
+        if f == "" {
+            continue
+        }
+        plan = append(plan, planEntry{Path: f, Mtime: mtime, Commit: hash})
+    }
+    return
+}
+
+// Gives files changed by HEAD its time, exact as from history, and all
+// other tracked files the committer time of its parent. Those last
+// changed no later than the parent, so they are never newer than they
+// should be and still older than the files of HEAD, which is what make
+// needs.
+func approxPlan(pathspecs []string) (plan []planEntry, err error) {
+    hash, err := gitResolveCommit("HEAD")
+    if err != nil {
+        return
+    }
+    mtime, fs, err := getCommitFiles(hash, pathspecs...)
+    if err != nil {
+        return
+    }
+
+    changed := map[string]bool{}
+    for _, f := range fs {
+        changed[f] = true
+        plan = append(plan, planEntry{Path: f, Mtime: mtime, Commit: hash})
+    }
+
+    // Root commit has all files changed
+    if _, err := gitResolveCommit("HEAD^"); err != nil {
+        return plan, nil
+    }
+    rest, err := commitPlan("HEAD^", pathspecs)
+    if err != nil {
+        return
+    }
+    for _, e := range rest {
+        if !changed[e.Path] {
+            plan = append(plan, e)
+        }
+    }
+    return
+}
+
+// Exit status of a run stopped by a missing or corrupt index.
+const exitBadIndex = 4
+
+// Stops with a hint if the index is missing, empty or corrupt while HEAD
+// has files, git would list no files of it and nothing would be retimed.
+// A repository empty at HEAD is fine. With rebuild-index policy the
+// index is re-created from HEAD instead.
+func checkIndex(modes map[string]string, opts Options) {
+    if len(modes) == 0 {
+        return
+    }
+    n, ok, err := gitIndexEntries()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading git index: %v\n", err)
+        exit(1)
+    }
+    if ok && n > 0 {
+        return
+    }
+
+    problem := "missing or empty"
+    if !ok {
+        problem = "corrupt"
+    }
+    if opts.OnMissingGit == "rebuild-index" {
+        fmt.Fprintf(os.Stderr, "WARNING git index is %v while HEAD has %d files, rebuilding it from HEAD\n", problem, len(modes))
+        if err := gitReadTree(); err != nil {
+            fmt.Fprintf(os.Stderr, "Error rebuilding git index: %v\n", err)
+            exit(1)
+        }
+        return
+    }
+    fmt.Fprintf(os.Stderr, "Error git index is %v while HEAD has %d files, git would list none of them\n", problem, len(modes))
+    fmt.Fprintln(os.Stderr, "Rebuild it with 'git read-tree HEAD', or 'git reset' which also unstages changes, or run with --on-missing-git rebuild-index")
+    exit(exitBadIndex)
+}
+
+// Narrows files at HEAD to those matching pathspecs.
+func trackedFiles(modes map[string]string, pathspecs []string) (tracked map[string]string, err error) {
+    if len(pathspecs) == 0 {
+        return modes, nil
+    }
+
+    gitDir, err := gitAbsoluteDir()
+    if err != nil {
+        return
+    }
+    fs, err := gitListFiles(gitDir, pathspecs...)
+    if err != nil {
+        return
+    }
+
+    tracked = map[string]string{}
+    for _, f := range fs {
+        if mode, ok := modes[f]; ok {
+            tracked[f] = mode
+        }
+    }
+    return
+}
+
+// Returns pathspecs of files to retime, those of --path narrowed by
+// excludes.
+func planPathspecs(opts Options) []string {
+    return append(append([]string{}, opts.Paths...), excludePathspecs(opts.Excludes)...)
+}
+
+// Returns git log arguments selecting commits to take times from.
+// Authors are matched after mapping through .mailmap, whatever log.mailmap
+// is set to, so identities agree with the rest of git tooling.
+func commitFilter(opts Options) (args []string) {
+    if opts.Author != "" {
+        args = append(args, "--use-mailmap", "--author="+opts.Author)
+    }
+    return
+}
+
+// Adds tracked files missing from the plan, flagged as unresolved.
+// With first-commit sentinel they get the author time of the oldest root
+// commit, the earliest time the repository knows of. With keep sentinel
+// their current mtime is left alone, they are only reported.
+func addUnresolved(plan []planEntry, tracked map[string]string, sentinel string) (all []planEntry, count int, err error) {
+    var mtime time.Time
+    if sentinel == "first-commit" {
+        if mtime, err = gitFirstCommitDate(); err != nil {
+            return
+        }
+    }
+
+    resolved := map[string]bool{}
+    for _, e := range plan {
+        resolved[e.Path] = true
+    }
+
+    all = plan
+    for f := range tracked {
+        if !resolved[f] {
+            all = append(all, planEntry{Path: f, Mtime: mtime, Unresolved: true})
+            count++
+        }
+    }
+
+    sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+    return
+}
+
+// Resolves times of files differing between two commits, each to its
+// newest commit reachable from the second but not from the first.
+// Files changed and changed back within the range are left alone.
+func rangePlan(ctx context.Context, from, to string, filter []string, pathspecs []string, resolver string, contentOnly bool) (plan []planEntry, err error) {
+    for _, rev := range []string{from, to} {
+        if _, err = gitResolveCommit(rev); err != nil {
+            return nil, fmt.Errorf("%v: %v", rev, err)
+        }
+    }
+
+    changed, err := gitChangedFiles(from, to, pathspecs)
+    if err != nil {
+        return
+    }
+    want := map[string]string{}
+    for _, f := range changed {
+        want[f] = ""
+    }
+
+    // Running out of time still leaves files resolved so far
+    all, err := buildPlan(ctx, append(filter, from+".."+to), want, pathspecs, resolver, contentOnly)
+    if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+        return
+    }
+    for _, e := range all {
+        if _, ok := want[e.Path]; ok {
+            plan = append(plan, e)
+        }
+    }
+    return
+}
+
+// Reads dates and files of all commits at once, returning lookup of them.
+func batchResolver(hashes []string, pathspecs []string) (resolve func(string) (time.Time, []string, error), err error) {
+    var valid []string
+    for _, hash := range hashes {
+        if hash != "" {
+            valid = append(valid, hash)
+        }
+    }
+    if len(valid) == 0 {
+        return func(string) (time.Time, []string, error) { return time.Time{}, nil, nil }, nil
+    }
+
+    files, err := gitCommitsFiles(valid, pathspecs)
+    if err != nil {
+        return
+    }
+    dates, err := gitCommitTimes(valid)
+    if err != nil {
+        return
+    }
+
+    resolve = func(hash string) (time.Time, []string, error) {
+        return dates[hash], files[hash], nil
+    }
+    return
+}
+
+// Resolves newest commit time of each file ever committed, or in commits
+// selected by git log arguments if given.
+// Commits are listed newest first, so first time seen wins.
+// Walk stops once all wanted files, usually those tracked at HEAD, have
+// their time, older commits could only add files since deleted. Hot
+// files touched by most commits so cost nothing past their newest commit.
+// Pathspecs let git itself skip commits and files outside of them.
+// The catfile resolver reads all commits with two batched git calls
+// up front instead of one per commit, so it doesn't stop early but saves
+// starting git thousands of times in long histories.
+// Once the context is done files resolved so far are returned with its
+// error, their times are final.
+// Content only leaves out changes of mode alone, such files take time
+// of an older commit changing their content.
+func buildPlan(ctx context.Context, revArgs []string, tracked map[string]string, pathspecs []string, resolver string, contentOnly bool) (plan []planEntry, err error) {
+    // Get all commits
+    hashes, err := getCommits(revArgs, pathspecs...)
+    if err != nil {
+        return
+    }
+
+    // Pathspecs too many for one command line would take several calls
+    // for each commit, batched resolver takes several for all
+    resolve := func(hash string) (time.Time, []string, error) {
+        return getCommitFiles(hash, pathspecs...)
+    }
+    if resolver == "catfile" || !pathspecsFit(pathspecs) {
+        if resolve, err = batchResolver(hashes, pathspecs); err != nil {
+            return
+        }
+    }
+
+    var modeOnly map[string]map[string]bool
+    if contentOnly {
+        if modeOnly, err = gitModeOnlyChanges(revArgs, pathspecs); err != nil {
+            return
+        }
+    }
+
+    seen := map[string]bool{}
+    pending := len(tracked)
+
+    // For each commit
+    for _, hash := range hashes {
+        if pending == 0 {
+            break
+        }
+        if ctx.Err() != nil {
+            return plan, ctx.Err()
+        }
+        if hash == "" {
+            continue
+        }
+        mtime, fs, err := resolve(hash)
+        if err != nil {
+            return nil, err
+        }
+
+        for _, f := range fs {
+            if seen[f] || modeOnly[hash][f] {
+                continue
+            }
+            seen[f] = true
+            if _, ok := tracked[f]; ok {
+                pending--
+            }
+            plan = append(plan, planEntry{Path: f, Mtime: mtime, Commit: hash})
+        }
+    }
+
+    sort.Slice(plan, func(i, j int) bool { return plan[i].Path < plan[j].Path })
+    return
+}
+
+// Replaces times of files from commits having a time note.
+// Notes replace the commit time itself, so the newest commit of a file
+// still decides, with its noted time.
+func applyNotes(plan []planEntry, notes map[string]time.Time) (count int) {
+    for i := range plan {
+        if t, ok := notes[plan[i].Commit]; ok {
+            plan[i].Mtime = t
+            count++
+        }
+    }
+    return
+}
+
+// Replaces times of files with that of the earliest tag containing their
+// commit. Tags are taken oldest first, each claiming commits no older
+// tag contains, so every commit is looked up once per tag at most in a
+// single rev-list. Files of commits no tag contains keep their time.
+func applyReleaseTags(plan []planEntry) (count, unreleased int, err error) {
+    tags, err := gitCommitTags()
+    if err != nil {
+        return
+    }
+
+    wanted := map[string]bool{}
+    for _, e := range plan {
+        if e.Commit != "" {
+            wanted[e.Commit] = true
+        }
+    }
+
+    released := map[string]time.Time{}
+    var older []string
+    for _, tag := range tags {
+        if len(released) == len(wanted) {
+            break
+        }
+        hashes, err := gitCommitsNotIn(tag.Ref, older)
+        if err != nil {
+            return 0, 0, err
+        }
+        for _, hash := range hashes {
+            if wanted[hash] {
+                released[hash] = tag.Date
+            }
+        }
+        older = append(older, tag.Ref)
+    }
+
+    for i, e := range plan {
+        if t, ok := released[e.Commit]; ok {
+            plan[i].Mtime = t
+            count++
+        } else if e.Commit != "" {
+            unreleased++
+        }
+    }
+    return
+}
+
+// Gives staged files the time, adding those new in the index. Their
+// commit is left empty as no commit has their content yet.
+func applyStaged(plan []planEntry, staged []string, t time.Time) []planEntry {
+    want := map[string]bool{}
+    for _, f := range staged {
+        want[f] = true
+    }
+
+    for i, e := range plan {
+        if want[e.Path] {
+            plan[i] = planEntry{Path: e.Path, Mtime: t}
+            delete(want, e.Path)
+        }
+    }
+    for _, f := range staged {
+        if want[f] {
+            plan = append(plan, planEntry{Path: f, Mtime: t})
+        }
+    }
+    return plan
+}
+
+// Moves times of files forward so that no commit is older than any
+// commit before it in history, taking the newest time of those before.
+// Times already in order stay as they are, skewed ones are raised to
+// the time they must not precede, never past it. Files without commit
+// keep their time.
+func alignPlan(plan []planEntry) (count int, err error) {
+    times := map[string]time.Time{}
+    for _, e := range plan {
+        if e.Commit != "" {
+            times[e.Commit] = e.Mtime
+        }
+    }
+    if len(times) == 0 {
+        return
+    }
+
+    hashes := make([]string, 0, len(times))
+    for hash := range times {
+        hashes = append(hashes, hash)
+    }
+    order, err := gitTopoOrder(hashes)
+    if err != nil {
+        return
+    }
+
+    // Parents come first, newest time so far bounds the rest. Branches
+    // merged later bound each other too, a linear order can't tell them
+    // apart, which errs on the side of later times
+    var newest time.Time
+    for _, hash := range order {
+        t, ok := times[hash]
+        if !ok {
+            continue
+        }
+        if t.Before(newest) {
+            times[hash] = newest
+        } else {
+            newest = t
+        }
+    }
+
+    for i, e := range plan {
+        if t, ok := times[e.Commit]; ok && !t.Equal(e.Mtime) {
+            plan[i].Mtime = t
+            count++
+        }
+    }
+    return
+}
+
+// Returns newest time of the plan.
+func newestTime(plan []planEntry) (newest time.Time) {
+    for _, e := range plan {
+        if e.Mtime.After(newest) {
+            newest = e.Mtime
+        }
+    }
+    return
+}
+
+// Prints number of files and commits of the plan and its time range,
+// to tell an empty or huge plan before it's applied.
+func printPlanSummary(plan []planEntry) {
+    if len(plan) == 0 {
+        fmt.Fprintln(os.Stderr, "Plan: 0 files")
+        return
+    }
+
+    commits := map[string]bool{}
+    oldest := plan[0].Mtime
+    for _, e := range plan {
+        if e.Commit != "" {
+            commits[e.Commit] = true
+        }
+        if e.Mtime.Before(oldest) {
+            oldest = e.Mtime
+        }
+    }
+    fmt.Fprintf(os.Stderr, "Plan: %d files from %d commits, %v to %v\n",
+        len(plan), len(commits), oldest.Format(time.RFC3339), newestTime(plan).Format(time.RFC3339))
+}
+
+// Commit giving files of the plan their time.
+type planCommit struct {
+    Commit string    `json:"commit"`
+    Mtime  time.Time `json:"mtime"`
+    Files  int       `json:"files"`
+}
+
+// Lists distinct commits of the plan with number of files each gave
+// its time, newest first. Files timed otherwise, like by overrides, are
+// only counted.
+func planCommits(plan []planEntry) (commits []planCommit, other int) {
+    index := map[string]int{}
+    for _, e := range plan {
+        if e.Commit == "" {
+            other++
+            continue
+        }
+        i, seen := index[e.Commit]
+        if !seen {
+            i = len(commits)
+            index[e.Commit] = i
+            commits = append(commits, planCommit{Commit: e.Commit, Mtime: e.Mtime})
+        }
+        commits[i].Files++
+    }
+    sort.Slice(commits, func(i, j int) bool {
+        if !commits[i].Mtime.Equal(commits[j].Mtime) {
+            return commits[i].Mtime.After(commits[j].Mtime)
+        }
+        return commits[i].Commit < commits[j].Commit
+    })
+    return
+}
+
+// Groups files by their directory up to depth levels and returns each
+// with the newest time of its files, sorted by path. Directories are
+// shown with a trailing slash, the top as "./". Files deleted from HEAD
+// don't count.
+func newestPerDir(plan []planEntry, modes map[string]string, depth int) (dirs []planEntry) {
+    newest := map[string]planEntry{}
+    for _, e := range plan {
+        if _, tracked := modes[e.Path]; !tracked {
+            continue
+        }
+        parts := strings.Split(path.Dir(e.Path), "/")
+        d := strings.Join(parts[:min(len(parts), depth)], "/")
+        if cur, ok := newest[d]; !ok || e.Mtime.After(cur.Mtime) {
+            newest[d] = planEntry{Path: d + "/", Mtime: e.Mtime, Commit: e.Commit}
+        }
+    }
+
+    for _, e := range newest {
+        dirs = append(dirs, e)
+    }
+    sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+    return
 }
 
-// Get full commit list and files updated at each commit.
-func logWalk(gitDir string) {
-    // Get all commits
-    hashes, err := getCommits()
-    if err != nil {
-        fmt.Fprintf(os.Stderr, "Error listing git commits: %v", err)
-        os.Exit(1)
+// Gives every file the newest time of files in its top level directory.
+// Files at the top are components of their own. Files deleted from HEAD
+// don't make their component newer.
+func componentPlan(plan []planEntry, modes map[string]string) {
+    newest := map[string]planEntry{}
+    for _, e := range plan {
+        if _, tracked := modes[e.Path]; !tracked {
+            continue
+        }
+        c, _, _ := strings.Cut(e.Path, "/")
+        if cur, ok := newest[c]; !ok || e.Mtime.After(cur.Mtime) {
+            newest[c] = e
+        }
     }
 
-    // For each commit
-    for _, hash := range hashes {
-        if hash == "" {
+    for i, e := range plan {
+        c, _, _ := strings.Cut(e.Path, "/")
+        if n, ok := newest[c]; ok {
+            plan[i].Mtime, plan[i].Commit = n.Mtime, n.Commit
+        }
+    }
+}
+
+// First made up time of --debug-spread, plainly not a real one.
+var debugSpreadBase = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Gives files of the plan times step apart from base, in order of path.
+func spreadPlan(plan []planEntry, base time.Time, step time.Duration) {
+    order := make([]int, len(plan))
+    for i := range order {
+        order[i] = i
+    }
+    sort.Slice(order, func(a, b int) bool { return plan[order[a]].Path < plan[order[b]].Path })
+
+    for n, i := range order {
+        plan[i].Mtime = base.Add(time.Duration(n) * step)
+    }
+}
+
+// Snaps each time to the nearest multiple of d, halfway values round up.
+// Multiples are counted from zero time, so for durations dividing a day
+// they fall on the same boundaries as in Unix time, in UTC.
+func roundPlan(plan []planEntry, d time.Duration) {
+    for i := range plan {
+        plan[i].Mtime = plan[i].Mtime.Round(d)
+    }
+}
+
+// Counts files with time after now, setting them to now if clamping.
+func checkFuture(plan []planEntry, now time.Time, clamp bool) (count int) {
+    for i := range plan {
+        if plan[i].Mtime.After(now) {
+            count++
+            if clamp {
+                plan[i].Mtime = now
+            }
+        }
+    }
+    return
+}
+
+// Disk state of a plan file, found before applying.
+type fileState struct {
+    Info  os.FileInfo // Lstat of file, nil on error
+    Err   error       // Lstat error
+    Mtime time.Time   // Current mtime setMtime would change, of symlink target unless not following
+}
+
+// Device and inode of a file.
+type inodeKey struct {
+    Dev, Ino uint64
+}
+
+// Finds plan files that are hard links to a file retimed by another
+// name. The name with newest time is kept, as links share one mtime.
+// Symlinks are left out, Chtimes changes their target.
+func hardlinkDupes(plan []planEntry, states []fileState) (dupes []bool) {
+    dupes = make([]bool, len(plan))
+    kept := map[inodeKey]int{}
+    for i, st := range states {
+        if st.Info == nil || !st.Info.Mode().IsRegular() {
             continue
         }
-        mtime, fs, err := getCommitFiles(hash)
-        if err != nil {
-            fmt.Fprintf(os.Stderr, "Error listing git commit files: %v", err)
-            os.Exit(1)
+        id, ok := fileID(st.Info)
+        if !ok {
+            continue
         }
 
-        // Update each file
-        for _, f := range fs {
-            fmt.Println(mtime, ":", f)
+        j, seen := kept[id]
+        switch {
+        case !seen:
+            kept[id] = i
+        case plan[i].Mtime.After(plan[j].Mtime):
+            dupes[j] = true
+            kept[id] = i
+        default:
+            dupes[i] = true
+        }
+    }
+    return
+}
+
+// Maps git paths of the plan to paths on disk under root.
+// Paths of files the mapper skips are left empty.
+func localPaths(root string, plan []planEntry, mapper func(string) (string, bool)) (fpaths []string) {
+    if mapper == nil {
+        mapper = identityPath
+    }
+
+    fpaths = make([]string, len(plan))
+    for i, e := range plan {
+        if local, ok := mapper(e.Path); ok {
+            fpaths[i] = path.Join(root, local)
+        }
+    }
+    return
+}
+
+// Stats all files using given number of workers, empty paths are left out.
+// Stat latency dominates on network file systems, so it pays to have
+// several in flight.
+func statPlan(fpaths []string, jobs int, nofollow bool) (states []fileState) {
+    states = make([]fileState, len(fpaths))
+    if jobs < 1 {
+        jobs = 1
+    }
+
+    idx := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < jobs; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range idx {
+                states[i] = statFile(fpaths[i], nofollow)
+            }
+        }()
+    }
+    for i, fpath := range fpaths {
+        if fpath != "" {
+            idx <- i
+        }
+    }
+    close(idx)
+    wg.Wait()
+    return
+}
+
+// Stats single file, symlinks by their target unless told not to follow.
+func statFile(fpath string, nofollow bool) (st fileState) {
+    st.Info, st.Err = os.Lstat(fpath)
+    if st.Err != nil {
+        return
+    }
+    st.Mtime = st.Info.ModTime()
 
-            fpath := path.Join(gitDir, f)
-            if _, err := os.Stat(fpath); os.IsNotExist(err) {
-                fmt.Fprintf(os.Stderr, "SKIP not existing file: %v", f)
+    // Chtimes follows symlinks, so the target is what changes
+    if st.Info.Mode()&os.ModeSymlink != 0 && !nofollow {
+        if target, err := os.Stat(fpath); err == nil {
+            st.Mtime = target.ModTime()
+        } else {
+            st.Mtime = time.Time{}
+        }
+    }
+    return
+}
+
+// Number of permission errors with nothing applied, after which
+// the work tree is taken for read-only.
+const readOnlyErrors = 5
+
+// Updates each file of the plan, reporting and counting failures.
+// If the first files all fail for permissions the tree is likely
+// mounted read-only, so it stops instead of failing every file, unless
+// told to keep going.
+// Disk paths come from the path mapper of the options.
+// Modes are git file modes at HEAD, see gitTreeModes. Files deleted
+// from HEAD are expected to be gone and skipped quietly.
+// With a chunk size set, pauses between chunks to let other processes
+// have a share of the disk.
+// Assuming files present, none is stat'ed first, a missing one is only
+// found by failing to retime it.
+// Counts add up over calls, so a plan applied in parts counts as one.
+func (stats *runStats) apply(root string, plan []planEntry, modes map[string]string, opts Options) (err error) {
+    fpaths := localPaths(root, plan, opts.PathMapper)
+    var states []fileState
+    if !opts.AssumePresent {
+        states = statPlan(fpaths, opts.StatJobs, opts.NoDereference)
+    }
+
+    var dupes []bool
+    if opts.DedupeLinks {
+        dupes = hardlinkDupes(plan, states)
+    }
+
+    // Chunks go on across calls, as stream mode applies batch by batch
+    for i, e := range plan {
+        if opts.ChunkSize > 0 && stats.chunkCalls == opts.ChunkSize {
+            time.Sleep(opts.ChunkPause)
+            stats.chunkCalls = 0
+        }
+
+        fpath := fpaths[i]
+        if fpath == "" {
+            stats.Unmapped++
+            continue
+        }
+
+        var st fileState
+        if states != nil {
+            st = states[i]
+        }
+        if os.IsNotExist(st.Err) {
+            stats.skipMissing(e.Path, modes, opts)
+            continue
+        }
+
+        // Git may know it as a file while now it's a directory on disk
+        if st.Info != nil && !sameKind(modes[e.Path], st.Info.Mode()) {
+            if !opts.QuietSkips {
+                fmt.Fprintf(os.Stderr, "SKIP type mismatch, git has %v but disk has %v: %v\n",
+                    gitKind(modes[e.Path]), diskKind(st.Info.Mode()), e.Path)
+            }
+            stats.TypeMismatch++
+            continue
+        }
+
+        // Submodules are directories of another repository
+        if modes[e.Path] == "160000" && !opts.Gitlinks {
+            stats.Gitlinks++
+            continue
+        }
+
+        if dupes != nil && dupes[i] {
+            stats.Deduped++
+            continue
+        }
+
+        // Sentinel keeping current time, only reported
+        if e.Unresolved && opts.Sentinel == "keep" {
+            e.Mtime = st.Mtime
+            printEntry(e, opts)
+            continue
+        }
+
+        if opts.SkipUnchanged && st.Mtime.Equal(e.Mtime) {
+            stats.Unchanged++
+            continue
+        }
+        if opts.NewerOnly && !e.Mtime.After(st.Mtime) {
+            stats.NotNewer++
+            continue
+        }
+
+        if opts.DryRun {
+            if opts.Diff {
+                printDiff(e, st, opts.TimeFormat)
+            } else {
+                printEntry(e, opts)
+            }
+            continue
+        }
+
+        // Best effort against clobbering an edit since the stat, a write
+        // may still come between this and Chtimes
+        if opts.NewerOnly {
+            if cur := statFile(fpath, opts.NoDereference); cur.Err == nil && cur.Mtime.After(st.Mtime) {
+                if !opts.QuietSkips {
+                    fmt.Fprintf(os.Stderr, "SKIP changed while running: %v\n", e.Path)
+                }
+                stats.Raced++
                 continue
             }
+        }
 
-            // Change mtime of this file
-            err = os.Chtimes(fpath, mtime, mtime)
-            if err != nil {
-                fmt.Fprintf(os.Stderr, "Error changing file mtime: %v", err)
-                os.Exit(1)
+        // Templates may show the outcome, printed once known, as are
+        // files not known to exist
+        printFirst := opts.Format != "template" && !opts.AssumePresent
+        if printFirst {
+            printEntry(e, opts)
+        }
+
+        // Change mtime of this file
+        stats.chunkCalls++
+        if err := setMtime(fpath, e.Mtime, opts); err != nil {
+            if os.IsNotExist(err) {
+                // Dangling symlink
+                stats.skipMissing(e.Path, modes, opts)
+                continue
+            }
+
+            if stats.showError(opts.ErrorLimit) {
+                fmt.Fprintf(os.Stderr, "Error changing file mtime: %v\n", err)
+            }
+            printOutcome(e, opts, false, err)
+            stats.addError(e.Path, err)
+            if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS) {
+                stats.Denied++
+            }
+            if !opts.KeepGoing && stats.Applied == 0 && stats.Denied == readOnlyErrors {
+                err = fmt.Errorf("working tree appears read-only, first %d files failed for permissions; use --keep-going to try all files", stats.Denied)
+                return err
+            }
+            continue
+        }
+        if !printFirst && opts.Format != "template" {
+            printEntry(e, opts)
+        }
+        printOutcome(e, opts, true, nil)
+        recordApplied(e.Path)
+        stats.Applied++
+
+        if opts.Birthtime != "" {
+            created := e.Created
+            if created.IsZero() {
+                created = e.Mtime
             }
+            if err := setBirthtime(fpath, created); err != nil {
+                fmt.Fprintf(os.Stderr, "WARNING could not change file creation time: %v\n", err)
+            }
+        }
+
+        if stats.Oldest.IsZero() || e.Mtime.Before(stats.Oldest) {
+            stats.Oldest = e.Mtime
+        }
+        if e.Mtime.After(stats.Newest) {
+            stats.Newest = e.Mtime
+        }
+
+        // Whole pipeline proved to work
+        if opts.UntilFirst {
+            fmt.Fprintf(os.Stderr, "Stopped after first file retimed, as --until-first-applied tells: %v %v\n", e.Path, formatTime(e.Mtime, opts.TimeFormat))
+            return
+        }
+    }
+    return
+}
+
+// Updates each file of the plan, see runStats.apply, returning counts.
+func applyPlan(root string, plan []planEntry, modes map[string]string, opts Options) (stats runStats, err error) {
+    err = stats.apply(root, plan, modes, opts)
+    return
+}
+
+// Changes modification time of file, as options tell. Nothing but the
+// one syscall touches the file, it isn't opened.
+// Access time is set the same unless kept, symlinks are followed unless
+// told not to.
+func setMtime(fpath string, t time.Time, opts Options) error {
+    atime := t
+    if opts.KeepAtime {
+        atime = time.Time{}
+    }
+    return changeTimes(fpath, atime, t, opts.NoDereference)
+}
+
+// Changes times of file, or of symlink itself with nofollow. Zero time
+// is kept as is. Replaceable to count calls instead of changing files.
+var changeTimes = func(fpath string, atime, mtime time.Time, nofollow bool) error {
+    if nofollow {
+        return lchtimes(fpath, atime, mtime)
+    }
+    return os.Chtimes(fpath, atime, mtime)
+}
+
+// Prints current and new time of file if they differ, in time format
+// of --time-format. Times are shown in local time zone to be comparable.
+func printDiff(e planEntry, st fileState, format string) {
+    if st.Mtime.Equal(e.Mtime) {
+        return
+    }
+
+    printRecord("%v: %v -> %v\n", e.Path, formatTime(st.Mtime.Local(), format), formatTime(e.Mtime.Local(), format))
+}
+
+// Records file not existing on disk if tracked at HEAD.
+// Reported at once unless failing on missing files, which lists them at end.
+func (stats *runStats) skipMissing(f string, modes map[string]string, opts Options) {
+    if _, tracked := modes[f]; !tracked {
+        return
+    }
+    if !opts.FailMissing && !opts.QuietSkips {
+        fmt.Fprintf(os.Stderr, "SKIP not existing file: %v\n", f)
+    }
+    stats.Missing = append(stats.Missing, f)
+}
+
+// Tells if disk file mode matches git file mode.
+// Paths gone from HEAD were files or symlinks when committed.
+func sameKind(gitMode string, mode os.FileMode) bool {
+    switch gitMode {
+    case "":
+        return mode.IsRegular() || mode&os.ModeSymlink != 0
+    case "120000":
+        return mode&os.ModeSymlink != 0
+    case "160000":
+        return mode.IsDir()
+    default:
+        return mode.IsRegular()
+    }
+}
+
+// Names kind of git file mode.
+func gitKind(gitMode string) string {
+    switch gitMode {
+    case "120000":
+        return "symlink"
+    case "160000":
+        return "submodule"
+    default:
+        return "file"
+    }
+}
+
+// Names kind of disk file mode.
+func diskKind(mode os.FileMode) string {
+    switch {
+    case mode.IsRegular():
+        return "file"
+    case mode&os.ModeSymlink != 0:
+        return "symlink"
+    case mode.IsDir():
+        return "directory"
+    default:
+        return "special file"
+    }
+}
+
+// Keeps only files of the plan with time after given one.
+func filterSince(plan []planEntry, since time.Time) (kept []planEntry, older int) {
+    for _, e := range plan {
+        if !e.Mtime.After(since) {
+            older++
+            continue
         }
+        kept = append(kept, e)
     }
+    return
 }
 
-// Lists all commits.
-func getCommits() (hashes []string, err error) {
+// Keeps files of the plan added after baseline, given as RFC3339 time
+// or as commit. Files are added after a commit if added by a commit not
+// reachable from it, after a time if first added at a later time.
+func filterAdded(plan []planEntry, baseline string, pathspecs []string) (kept []planEntry, older int, err error) {
+    var added map[string]time.Time
+    since, perr := time.Parse(time.RFC3339, baseline)
+    if perr == nil {
+        added, err = gitAddedTimes(nil, pathspecs)
+    } else {
+        if _, err = gitResolveCommit(baseline); err != nil {
+            return
+        }
+        added, err = gitAddedTimes([]string{baseline + "..HEAD"}, pathspecs)
+    }
+    if err != nil {
+        return
+    }
+
+    for _, e := range plan {
+        t, ok := added[e.Path]
+        if !ok || (perr == nil && !t.After(since)) {
+            older++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
+}
 
-    cmd := exec.Command("git", "log", "--pretty=%H")
-    out, err := cmd.CombinedOutput()
+// Drops files of the plan git archive leaves out, those with
+// export-ignore set on them or on any of their directories.
+func filterExportIgnored(plan []planEntry) (kept []planEntry, count int, err error) {
+    var paths []string
+    seen := map[string]bool{}
+    for _, e := range plan {
+        for p := e.Path; p != "." && !seen[p]; p = path.Dir(p) {
+            seen[p] = true
+            paths = append(paths, p)
+        }
+    }
+    ignored, err := gitExportIgnored(paths)
     if err != nil {
-        err = errors.New(string(out))
         return
     }
 
-    hashes = strings.Split(string(out), "\n")
-    return 
+    for _, e := range plan {
+        skip := false
+        for p := e.Path; p != "." && !skip; p = path.Dir(p) {
+            skip = ignored[p]
+        }
+        if skip {
+            count++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
+}
+
+// Drops files of the plan matching ignore rules.
+func filterIgnored(plan []planEntry) (kept []planEntry, count int, err error) {
+    files := make([]string, len(plan))
+    for i, e := range plan {
+        files[i] = e.Path
+    }
+    ignored, err := gitIgnoredFiles(files)
+    if err != nil {
+        return
+    }
+
+    for _, e := range plan {
+        if ignored[e.Path] {
+            count++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
 }
 
-// Files changed in particular commit.
-func getCommitFiles(hash string) (date time.Time, files []string, err error) {
-    cmd := exec.Command("git", "show",  "--name-only", "--pretty=%ad", hash)
-    out, err := cmd.CombinedOutput()
+// Drops files of the plan marked assume-unchanged or skip-worktree,
+// as asked, counting each.
+func filterLocalFlags(plan []planEntry, skipAssumed, skipWorktree bool) (kept []planEntry, assumed, worktree int, err error) {
+    flags, err := gitIndexFlags()
     if err != nil {
-        err = errors.New(string(out))
         return
     }
 
-    lines := strings.Split(string(out), "\n")
+    for _, e := range plan {
+        f := flags[e.Path]
+        if skipAssumed && f.AssumeUnchanged {
+            assumed++
+            continue
+        }
+        if skipWorktree && f.SkipWorktree {
+            worktree++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
+}
+
+// Keeps only binary or only text files of the plan.
+// Files not known to the index are dropped.
+func filterByClass(plan []planEntry, binary bool) (kept []planEntry, err error) {
+    classes, err := gitFileClasses()
+    if err != nil {
+        return
+    }
+
+    var texts, binaries int
+    for _, e := range plan {
+        isBinary, ok := classes[e.Path]
+        if !ok {
+            continue
+        }
+        if isBinary {
+            binaries++
+        } else {
+            texts++
+        }
+        if isBinary == binary {
+            kept = append(kept, e)
+        }
+    }
+
+    class := "text"
+    if binary {
+        class = "binary"
+    }
+    fmt.Fprintf(os.Stderr, "Classified %d text and %d binary files, retiming %s only\n", texts, binaries, class)
+    return
+}
+
+// Lists all commits, or those selected by git log arguments if given,
+// touching pathspecs if any.
+func getCommits(revArgs []string, pathspecs ...string) (hashes []string, err error) {
 
-    date, err = time.Parse("Mon Jan 2 15:04:05 2006 -0700", lines[0])
+    args := append([]string{"log", "--pretty=%H"}, revArgs...)
+    stdin, argv := withStdinPathspecs(args, pathspecs)
+    out, err := runGitInput(stdin, argv...)
     if err != nil {
-        err = errors.New("Could not understand this time stamp: " + lines[0])
+        return
+    }
+
+    hashes = strings.Split(string(out), "\n")
+    return 
+}
+
+// Files changed in particular commit, only those matching pathspecs if any,
+// and its time as --date-order picks it.
+// Many pathspecs take a call for each batch of them, see
+// pathspecBatches.
+func getCommitFiles(hash string, pathspecs ...string) (date time.Time, files []string, err error) {
+    batches := pathspecBatches(pathspecs)
+    if len(batches) == 1 {
+        return getCommitBatch(hash, pathspecs)
+    }
+
+    seen := map[string]bool{}
+    for _, batch := range batches {
+        d, fs, err := getCommitBatch(hash, batch)
+        if err != nil {
+            return date, nil, err
+        }
+        // Batches of pathspecs the commit doesn't change have no time
+        if len(fs) > 0 {
+            date = d
+        }
+        for _, f := range fs {
+            if !seen[f] {
+                seen[f] = true
+                files = append(files, f)
+            }
+        }
+    }
+    return
+}
+
+// Files changed in commit matching pathspecs and its time, with a single
+// git show. Output is NUL separated so file names come unquoted.
+// Commit changing none of the pathspecs prints nothing, it has no files
+// and no time is needed for them.
+func getCommitBatch(hash string, pathspecs []string) (date time.Time, files []string, err error) {
+    out, err := runGit(withPathspecs([]string{"show", "-z", "--name-only", "--pretty=%aI%x00%cI", hash}, pathspecs)...)
+    if err != nil || len(out) == 0 {
+        return
+    }
+
+    // Dates are followed by NUL each and newline, then files by NUL each
+    lines := strings.Split(string(out), "\x00")
+    if len(lines) < 2 {
+        return date, nil, errors.New("Could not understand this commit: " + string(out))
+    }
+
+    fields := map[string]time.Time{}
+    for i, field := range []string{"author", "commit"} {
+        t, err := ParseGitTime(lines[i], time.RFC3339)
+        if err != nil {
+            return date, nil, err
+        }
+        fields[field] = t
     }
+    date, _ = pickDate(fields)
 
-    for i := 1; i < len(lines); i++ {
-        if lines[i] == "" {
+    for i := 2; i < len(lines); i++ {
+        f := lines[i]
+        if i == 2 {
+            f = strings.TrimPrefix(f, "\n")
+        }
+        if f == "" {
             continue
         }
-        files = append(files, lines[i])
+        files = append(files, f)
     }
 
     return
@@ -121,13 +2234,6 @@ func mainFilesWalk() {
         os.Exit(1)
     }
 
-    // Validate GIT directory exists
-    gitDir := path.Join(os.Args[1], ".git")
-    if _, err := os.Stat(gitDir); os.IsNotExist(err) {
-        fmt.Fprintf(os.Stderr, "GIT directory doesn't exist: %v", gitDir)
-        os.Exit(1)
-    }
-
     // Validate GIT work tree directory exists
     gitTreeDir := path.Join(os.Args[1])
     if _, err := os.Stat(gitTreeDir); os.IsNotExist(err) {
@@ -135,6 +2241,15 @@ func mainFilesWalk() {
         os.Exit(1)
     }
 
+    // Validate GIT directory exists, asking git as .git is a file
+    // pointing elsewhere in linked worktrees and submodules
+    gitWorkTree = gitTreeDir
+    gitDir, err := gitAbsoluteDir()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "GIT directory doesn't exist: %v", err)
+        os.Exit(1)
+    }
+
     filesWalk(gitDir, gitTreeDir)
 }
 
@@ -191,28 +2306,23 @@ func filesWalk(gitDir, gitTreeDir string) {
     }
 }
 
-// Lists all files in GIT project.
-func gitListFiles(gitDir string) (fs []string, err error) {
+// Lists all files in GIT project, or those matching pathspecs.
+// Many pathspecs are listed in batches, files then sorted again.
+func gitListFiles(gitDir string, pathspecs ...string) (fs []string, err error) {
 
-    cmd := exec.Command("git", "--git-dir=" + gitDir, "ls-files")
-    out, err := cmd.CombinedOutput()
-    if err != nil {
-        err = errors.New(string(out))
-        return
+    fs, err = gitBatchedFiles([]string{"--git-dir=" + gitDir, "ls-files", "-z"}, pathspecs)
+    if err == nil && !pathspecsFit(pathspecs) {
+        sort.Strings(fs)
     }
-
-    fs = strings.Split(string(out), "\n")
-    return 
+    return
 }
 
 // Find last revision for given file.
 func gitFileRevision(gitDir, gitTreeDir, f string) (rev string, err error) {
 
     //cmd := exec.Command("git", "--git-dir=" + gitDir, "--work-tree=" + gitTreeDir, "rev-list", "-n", "1", "HEAD", filepath.ToSlash(f))
-    cmd := exec.Command("git", "rev-list", "-n", "1", "HEAD", filepath.ToSlash(f))
-    out, err := cmd.CombinedOutput()
+    out, err := runGit("rev-list", "-n", "1", "HEAD", filepath.ToSlash(f))
     if err != nil {
-        err = errors.New(string(out))
         return
     }
 
@@ -222,10 +2332,8 @@ func gitFileRevision(gitDir, gitTreeDir, f string) (rev string, err error) {
 
 // Parses given time of commit.
 func gitCommitTime(gitDir, gitTreeDir, hash string) (date time.Time, err error) {
-    cmd := exec.Command("git", "show", "--pretty=format:%ai", hash)
-    out, err := cmd.CombinedOutput()
+    out, err := runGit("show", "--pretty=format:%ai", hash)
     if err != nil {
-        err = errors.New(string(out))
         return
     }
 
@@ -235,9 +2343,6 @@ func gitCommitTime(gitDir, gitTreeDir, hash string) (date time.Time, err error)
         return
     }
 
-    date, err = time.Parse("2006-01-02 15:04:05 -0700", string(out[:idx]))
-    if err != nil {
-        err = errors.New("Could not understand this time stamp: " + string(out[:idx]))
-    }
+    date, err = ParseGitTime(string(out[:idx]), "2006-01-02 15:04:05 -0700")
     return
 }
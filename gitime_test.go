@@ -0,0 +1,1913 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "runtime"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+)
+
+//------------------------------------------------------------
+// Running gitime in test repositories
+//------------------------------------------------------------
+
+// Runs gitime itself when started by runGitime, tests otherwise. Git
+// reads no user or system config, so tests see the same git anywhere.
+func TestMain(m *testing.M) {
+    if os.Getenv("GITIME_TEST_MAIN") == "1" {
+        failChangeTimes(os.Getenv("GITIME_TEST_FAIL"))
+        main()
+        exit(0)
+    }
+    os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+    os.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+    os.Exit(m.Run())
+}
+
+// Errors changing times can be faked by name of file, as read-only trees
+// and the like can't be made without privileges.
+var testErrnos = map[string]syscall.Errno{
+    "EROFS":  syscall.EROFS,
+    "EACCES": syscall.EACCES,
+    "ENOENT": syscall.ENOENT,
+    "EINTR":  syscall.EINTR,
+    "EIO":    syscall.EIO,
+}
+
+// Makes changing times of files fail as spec tells, a comma separated
+// list of name=ERRNO with name the base name of files or * for all.
+func failChangeTimes(spec string) {
+    if spec == "" {
+        return
+    }
+    fails := map[string]syscall.Errno{}
+    for _, rule := range strings.Split(spec, ",") {
+        name, errno, _ := strings.Cut(rule, "=")
+        fails[name] = testErrnos[errno]
+    }
+    saved := changeTimes
+    changeTimes = func(fpath string, atime, mtime time.Time, nofollow bool) error {
+        errno, ok := fails[filepath.Base(fpath)]
+        if !ok {
+            errno, ok = fails["*"]
+        }
+        if ok {
+            return &os.PathError{Op: "chtimes", Path: fpath, Err: errno}
+        }
+        return saved(fpath, atime, mtime, nofollow)
+    }
+}
+
+// Outcome of running gitime.
+type gitimeRun struct {
+    Stdout string
+    Stderr string
+    Code   int
+}
+
+// Runs gitime in dir with arguments, feeding it stdin.
+func runGitime(t *testing.T, dir, stdin string, args ...string) (run gitimeRun) {
+    t.Helper()
+    return runGitimeEnv(t, dir, stdin, nil, args...)
+}
+
+// Runs gitime like runGitime with variables added to its environment.
+func runGitimeEnv(t *testing.T, dir, stdin string, env []string, args ...string) (run gitimeRun) {
+    t.Helper()
+    cmd := exec.Command(os.Args[0], args...)
+    cmd.Dir = dir
+    cmd.Env = append(append(os.Environ(), "GITIME_TEST_MAIN=1"), env...)
+    cmd.Stdin = strings.NewReader(stdin)
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+
+    err := cmd.Run()
+    var exitErr *exec.ExitError
+    switch {
+    case errors.As(err, &exitErr):
+        run.Code = exitErr.ExitCode()
+    case err != nil:
+        t.Fatal(err)
+    }
+    run.Stdout, run.Stderr = stdout.String(), stderr.String()
+    return
+}
+
+// Times of files gitime printed, by path, from text lines
+// "<time> [<commit>] : <path>".
+func (run gitimeRun) times(t *testing.T) (times map[string]time.Time) {
+    t.Helper()
+    times = map[string]time.Time{}
+    for _, line := range strings.Split(strings.TrimSpace(run.Stdout), "\n") {
+        before, f, ok := strings.Cut(line, " : ")
+        if !ok {
+            continue
+        }
+        date, err := time.Parse(time.RFC3339, strings.Fields(before)[0])
+        if err != nil {
+            t.Fatalf("bad time in %q: %v", line, err)
+        }
+        times[f] = date
+    }
+    return
+}
+
+// Repository made for a test, removed after it.
+type testRepo struct {
+    t   *testing.T
+    Dir string
+}
+
+// Makes empty repository.
+func newTestRepo(t *testing.T) *testRepo {
+    r := &testRepo{t: t, Dir: t.TempDir()}
+    r.git("init", "-q")
+    r.git("config", "user.name", "Test")
+    r.git("config", "user.email", "test@example.com")
+    return r
+}
+
+// Runs git in repository, returning its output.
+func (r *testRepo) git(args ...string) string {
+    r.t.Helper()
+    return r.gitAt("", args...)
+}
+
+// Runs git with author and committer time date, RFC3339, if given.
+func (r *testRepo) gitAt(date string, args ...string) string {
+    r.t.Helper()
+    cmd := exec.Command("git", args...)
+    cmd.Dir = r.Dir
+    cmd.Env = os.Environ()
+    if date != "" {
+        cmd.Env = append(cmd.Env, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+    }
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        r.t.Fatalf("git %v: %v\n%s", strings.Join(args, " "), err, out)
+    }
+    return string(out)
+}
+
+// Writes files, by path, and commits all changes at date.
+func (r *testRepo) commit(date string, files map[string]string) {
+    r.t.Helper()
+    for f, content := range files {
+        r.write(f, content)
+    }
+    r.git("add", "-A")
+    r.gitAt(date, "commit", "-q", "--allow-empty", "-m", "change at "+date)
+}
+
+// Writes file of the work tree.
+func (r *testRepo) write(f, content string) {
+    r.t.Helper()
+    fpath := r.path(f)
+    if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+        r.t.Fatal(err)
+    }
+    if err := os.WriteFile(fpath, []byte(content), 0644); err != nil {
+        r.t.Fatal(err)
+    }
+}
+
+// Path of file of the work tree.
+func (r *testRepo) path(f string) string {
+    return filepath.Join(r.Dir, filepath.FromSlash(f))
+}
+
+// Modification time of file of the work tree.
+func (r *testRepo) mtime(f string) time.Time {
+    r.t.Helper()
+    fi, err := os.Lstat(r.path(f))
+    if err != nil {
+        r.t.Fatal(err)
+    }
+    return fi.ModTime()
+}
+
+// Sets modification time of file of the work tree.
+func (r *testRepo) setMtime(f string, mtime time.Time) {
+    r.t.Helper()
+    if err := os.Chtimes(r.path(f), mtime, mtime); err != nil {
+        r.t.Fatal(err)
+    }
+}
+
+// Runs gitime in repository.
+func (r *testRepo) run(args ...string) gitimeRun {
+    r.t.Helper()
+    return runGitime(r.t, r.Dir, "", args...)
+}
+
+// Runs gitime in repository, changing times failing as failChangeTimes
+// spec tells.
+func (r *testRepo) runFailing(spec string, args ...string) gitimeRun {
+    r.t.Helper()
+    return runGitimeEnv(r.t, r.Dir, "", []string{"GITIME_TEST_FAIL=" + spec}, args...)
+}
+
+// Makes git commands of gitime itself run in repository for the rest of
+// the test.
+func (r *testRepo) enter() {
+    saved := gitWorkTree
+    gitWorkTree = r.Dir
+    r.t.Cleanup(func() { gitWorkTree = saved })
+}
+
+// Parses RFC3339 time of a test.
+func mustTime(t *testing.T, s string) time.Time {
+    t.Helper()
+    date, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return date
+}
+
+//------------------------------------------------------------
+// Canned git history
+//------------------------------------------------------------
+
+// Synthetic history standing in for git through gitRunner. Commits are
+// newest first, each touching a few files, low numbered files most often.
+type fakeHistory struct {
+    hashes []string
+    dates  []time.Time
+    files  [][]string
+    newest map[string]int // Newest commit touching each file
+    index  map[string]int // Commit of each hash
+}
+
+// Makes history of commits over files.
+func newFakeHistory(commits, files int) *fakeHistory {
+    h := &fakeHistory{newest: map[string]int{}, index: map[string]int{}}
+    base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+    for i := 0; i < commits; i++ {
+        h.hashes = append(h.hashes, fmt.Sprintf("%040x", i+1))
+        h.index[h.hashes[i]] = i
+        h.dates = append(h.dates, base.Add(time.Duration(commits-i)*time.Minute))
+
+        var fs []string
+        for _, n := range []int{i % 3, i % files, (i * 7) % files} {
+            f := fmt.Sprintf("dir%d/file%d.go", n%10, n)
+            if len(fs) > 0 && fs[len(fs)-1] == f {
+                continue
+            }
+            fs = append(fs, f)
+            if _, ok := h.newest[f]; !ok {
+                h.newest[f] = i
+            }
+        }
+        h.files = append(h.files, fs)
+    }
+
+    // Root commit adds every file
+    last := len(h.files) - 1
+    for n := 0; n < files; n++ {
+        f := fmt.Sprintf("dir%d/file%d.go", n%10, n)
+        if _, ok := h.newest[f]; !ok {
+            h.newest[f] = last
+            h.files[last] = append(h.files[last], f)
+        }
+    }
+    return h
+}
+
+// Tracked files of the history, all it ever had.
+func (h *fakeHistory) tracked() map[string]string {
+    tracked := map[string]string{}
+    for f := range h.newest {
+        tracked[f] = "100644"
+    }
+    return tracked
+}
+
+// Answers git commands gitime runs for the history, as gitRunner.
+func (h *fakeHistory) run(stdin []byte, args ...string) (stdout, stderr []byte, err error) {
+    for len(args) > 0 && (args[0] == "-C" || strings.HasPrefix(args[0], "--git-dir=")) {
+        if args[0] == "-C" {
+            args = args[1:]
+        }
+        args = args[1:]
+    }
+
+    var out bytes.Buffer
+    switch args[0] {
+    case "log":
+        for _, hash := range h.hashes {
+            out.WriteString(hash + "\n")
+        }
+    case "show":
+        i := h.index[args[len(args)-1]]
+        if args[1] == "--pretty=format:%ai" {
+            out.WriteString(h.dates[i].Format("2006-01-02 15:04:05 -0700") + "\n")
+            break
+        }
+        date := h.dates[i].Format(time.RFC3339)
+        out.WriteString(date + "\x00" + date + "\x00\n")
+        for _, f := range h.files[i] {
+            out.WriteString(f + "\x00")
+        }
+    case "rev-list":
+        out.WriteString(h.hashes[h.newest[args[len(args)-1]]] + "\n")
+    case "diff-tree":
+        for _, hash := range strings.Fields(string(stdin)) {
+            out.WriteString(hash + "\x00")
+            for _, f := range h.files[h.index[hash]] {
+                out.WriteString(f + "\x00")
+            }
+        }
+    case "cat-file":
+        for _, hash := range strings.Fields(string(stdin)) {
+            sec := h.dates[h.index[hash]].Unix()
+            body := fmt.Sprintf("tree %040x\nauthor A <a@a> %d +0000\ncommitter C <c@c> %d +0000\n\nchange\n", 0, sec, sec)
+            fmt.Fprintf(&out, "%v commit %d\n%v\n", hash, len(body), body)
+        }
+    default:
+        return nil, nil, fmt.Errorf("fake git: unexpected command %v", args)
+    }
+    return out.Bytes(), nil, nil
+}
+
+// Replaces git with history for the rest of the test.
+func (h *fakeHistory) install(tb testing.TB) {
+    saved := gitRunner
+    gitRunner = h.run
+    tb.Cleanup(func() { gitRunner = saved })
+}
+
+// Sizes of histories benchmarked, commits by files.
+var benchSizes = []struct{ commits, files int }{
+    {100, 50},
+    {1000, 500},
+    {5000, 2000},
+}
+
+// Makes a real repository with history of files touched like that of
+// newFakeHistory, through git fast-import, and runs git of gitime in it
+// for the rest of the benchmark.
+func newBenchRepo(b *testing.B, commits, files int) (modes map[string]string) {
+    h := newFakeHistory(commits, files)
+    var stream bytes.Buffer
+    for i := commits - 1; i >= 0; i-- {
+        fmt.Fprintf(&stream, "commit refs/heads/master\ncommitter C <c@c> %d +0000\ndata 0\n", h.dates[i].Unix())
+        for _, f := range h.files[i] {
+            content := fmt.Sprintf("%v at %d\n", f, i)
+            fmt.Fprintf(&stream, "M 644 inline %v\ndata %d\n%v", f, len(content), content)
+        }
+    }
+
+    dir := b.TempDir()
+    for _, args := range [][]string{{"init", "-q"}, {"fast-import", "--quiet"}, {"read-tree", "HEAD"}} {
+        cmd := exec.Command("git", args...)
+        cmd.Dir = dir
+        if args[0] == "fast-import" {
+            cmd.Stdin = &stream
+        }
+        if out, err := cmd.CombinedOutput(); err != nil {
+            b.Fatalf("git %v: %v\n%s", args[0], err, out)
+        }
+    }
+
+    saved := gitWorkTree
+    gitWorkTree = dir
+    b.Cleanup(func() { gitWorkTree = saved })
+    modes, err := gitTreeModes()
+    if err != nil {
+        b.Fatal(err)
+    }
+    return
+}
+
+//------------------------------------------------------------
+// Walk strategies
+//------------------------------------------------------------
+
+// Lists commits, then runs git show for each until all files are seen.
+func BenchmarkWalkShowPerCommit(b *testing.B) {
+    for _, size := range benchSizes {
+        h := newFakeHistory(size.commits, size.files)
+        b.Run(fmt.Sprintf("commits=%d/files=%d", size.commits, size.files), func(b *testing.B) {
+            h.install(b)
+            tracked := h.tracked()
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := buildPlan(context.Background(), nil, tracked, nil, "log", false); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// Lists commits, then reads all of them with one diff-tree and one
+// cat-file.
+func BenchmarkWalkSinglePass(b *testing.B) {
+    for _, size := range benchSizes {
+        h := newFakeHistory(size.commits, size.files)
+        b.Run(fmt.Sprintf("commits=%d/files=%d", size.commits, size.files), func(b *testing.B) {
+            h.install(b)
+            tracked := h.tracked()
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := buildPlan(context.Background(), nil, tracked, nil, "catfile", false); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// Asks git for the newest commit of each file and then its time, as the
+// original files walk does.
+func BenchmarkWalkPerFile(b *testing.B) {
+    for _, size := range benchSizes {
+        h := newFakeHistory(size.commits, size.files)
+        b.Run(fmt.Sprintf("commits=%d/files=%d", size.commits, size.files), func(b *testing.B) {
+            h.install(b)
+            tracked := h.tracked()
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                for f := range tracked {
+                    rev, err := gitFileRevision("", "", f)
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                    if _, err := gitCommitTime("", "", rev); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            }
+        })
+    }
+}
+
+// Walk stopping once all tracked files have their time, against one
+// walking all of history for a file it never finds, as a file deleted
+// long ago and still listed would make it.
+func BenchmarkWalkEarlyStop(b *testing.B) {
+    h := newFakeHistory(5000, 200)
+    h.install(b)
+    stops := h.tracked()
+    full := h.tracked()
+    full["deleted/long-ago.go"] = "100644"
+    for _, bench := range []struct {
+        name    string
+        tracked map[string]string
+    }{{"stops", stops}, {"full", full}} {
+        b.Run(bench.name, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := buildPlan(context.Background(), nil, bench.tracked, nil, "log", false); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// Both resolvers on the largest history, git per commit against one
+// cat-file batch.
+func BenchmarkResolver(b *testing.B) {
+    size := benchSizes[len(benchSizes)-1]
+    h := newFakeHistory(size.commits, size.files)
+    h.install(b)
+    tracked := h.tracked()
+    for _, resolver := range []string{"log", "catfile"} {
+        b.Run("resolver="+resolver, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := buildPlan(context.Background(), nil, tracked, nil, resolver, false); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// Memory held resolving a history, the whole plan against streaming it
+// as --max-memory does, which keeps only the set of files done. Heap in
+// use is sampled once per run, after a collection, commits listed by
+// both left out.
+func BenchmarkMaxMemory(b *testing.B) {
+    size := benchSizes[len(benchSizes)-1]
+    h := newFakeHistory(size.commits, size.files)
+    h.install(b)
+    tracked := h.tracked()
+    heap := func() uint64 {
+        var m runtime.MemStats
+        runtime.GC()
+        runtime.ReadMemStats(&m)
+        return m.HeapAlloc
+    }
+
+    b.Run("plan", func(b *testing.B) {
+        b.ReportAllocs()
+        var held uint64
+        for i := 0; i < b.N; i++ {
+            base := heap()
+            plan, err := buildPlan(context.Background(), nil, tracked, nil, "log", false)
+            if err != nil {
+                b.Fatal(err)
+            }
+            if i == 0 {
+                held = heap() - base
+            }
+            runtime.KeepAlive(plan)
+        }
+        b.ReportMetric(float64(held), "held-B")
+    })
+    b.Run("stream", func(b *testing.B) {
+        b.ReportAllocs()
+        var held uint64
+        for i := 0; i < b.N; i++ {
+            hashes, err := getCommits(nil)
+            if err != nil {
+                b.Fatal(err)
+            }
+            base := heap()
+            stop := make(chan struct{})
+            n := 0
+            for batch := range streamResolve(context.Background(), hashes, tracked, nil, nil, stop) {
+                if batch.err != nil {
+                    b.Fatal(batch.err)
+                }
+                if n++; i == 0 && n == size.files/2 {
+                    if now := heap(); now > base {
+                        held = now - base
+                    }
+                }
+            }
+            close(stop)
+        }
+        b.ReportMetric(float64(held), "held-B")
+    })
+}
+
+// Fast approximate plan of --approx against the accurate one walking
+// history, on a real repository as both ask git for different things.
+func BenchmarkApprox(b *testing.B) {
+    modes := newBenchRepo(b, 500, 200)
+    tracked, err := trackedFiles(modes, nil)
+    if err != nil {
+        b.Fatal(err)
+    }
+
+    b.Run("approx", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            if _, err := approxPlan(nil); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+    b.Run("accurate", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            if _, err := buildPlan(context.Background(), nil, tracked, nil, "log", false); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+}
+
+// Strategies must agree on the plan, or comparing them means nothing.
+func TestWalkStrategiesAgree(t *testing.T) {
+    h := newFakeHistory(200, 80)
+    h.install(t)
+    tracked := h.tracked()
+
+    show, err := buildPlan(context.Background(), nil, tracked, nil, "log", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    batched, err := buildPlan(context.Background(), nil, tracked, nil, "catfile", false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(show) != len(tracked) || len(batched) != len(tracked) {
+        t.Fatalf("plans have %d and %d files, want %d", len(show), len(batched), len(tracked))
+    }
+    for i, e := range show {
+        rev, err := gitFileRevision("", "", e.Path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        date, err := gitCommitTime("", "", rev)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !e.Mtime.Equal(batched[i].Mtime) || !e.Mtime.Equal(date) {
+            t.Errorf("%v: show %v, single pass %v, per file %v", e.Path, e.Mtime, batched[i].Mtime, date)
+        }
+    }
+}
+
+//------------------------------------------------------------
+// Applying
+//------------------------------------------------------------
+
+// Makes n empty files in a temporary directory, returning their paths.
+func benchFiles(b *testing.B, n int) (fpaths []string) {
+    dir := b.TempDir()
+    for i := 0; i < n; i++ {
+        fpath := filepath.Join(dir, fmt.Sprintf("file%d", i))
+        if err := os.WriteFile(fpath, nil, 0644); err != nil {
+            b.Fatal(err)
+        }
+        fpaths = append(fpaths, fpath)
+    }
+    return
+}
+
+// Stat phase of --skip-unchanged serial and with --stat-jobs. Local
+// disks gain little, stat latency of network file systems is what
+// parallel stat hides.
+func BenchmarkStatPlan(b *testing.B) {
+    fpaths := benchFiles(b, 2000)
+    for _, jobs := range []int{1, 4, 16} {
+        b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                states := statPlan(fpaths, jobs, false)
+                if states[len(states)-1].Err != nil {
+                    b.Fatal(states[len(states)-1].Err)
+                }
+            }
+        })
+    }
+}
+
+// Applying a plan with the stat of each file first and without, as
+// --assume-present does, one syscall less per file.
+func BenchmarkAssumePresent(b *testing.B) {
+    fpaths := benchFiles(b, 2000)
+    root := filepath.Dir(fpaths[0])
+    var plan []planEntry
+    modes := map[string]string{}
+    mtime := time.Unix(1600000000, 0)
+    for _, fpath := range fpaths {
+        f := filepath.Base(fpath)
+        plan = append(plan, planEntry{Path: f, Mtime: mtime})
+        modes[f] = "100644"
+    }
+
+    saved := recordOut
+    recordOut = io.Discard
+    b.Cleanup(func() { recordOut = saved })
+
+    for _, assume := range []bool{false, true} {
+        b.Run(fmt.Sprintf("assume-present=%v", assume), func(b *testing.B) {
+            opts := Options{AssumePresent: assume, Format: "text", TimeFormat: "rfc3339", NoDereference: true, KeepAtime: true, QuietSkips: true}
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                stats, err := applyPlan(root, plan, modes, opts)
+                if err != nil || stats.Applied != len(plan) {
+                    b.Fatalf("applied %d of %d: %v", stats.Applied, len(plan), err)
+                }
+            }
+        })
+    }
+}
+
+// Parallel stat gives each file its own state, in plan order.
+func TestStatPlanJobs(t *testing.T) {
+    dir := t.TempDir()
+    var fpaths []string
+    for i := 0; i < 50; i++ {
+        fpath := filepath.Join(dir, fmt.Sprintf("file%d", i))
+        if i%5 != 0 {
+            if err := os.WriteFile(fpath, nil, 0644); err != nil {
+                t.Fatal(err)
+            }
+            mtime := time.Unix(int64(1600000000+i), 0)
+            if err := os.Chtimes(fpath, mtime, mtime); err != nil {
+                t.Fatal(err)
+            }
+        }
+        fpaths = append(fpaths, fpath)
+    }
+
+    for _, jobs := range []int{0, 1, 8} {
+        states := statPlan(fpaths, jobs, false)
+        for i, st := range states {
+            switch {
+            case i%5 == 0 && !os.IsNotExist(st.Err):
+                t.Errorf("jobs %d: file%d is missing, got error %v", jobs, i, st.Err)
+            case i%5 != 0 && st.Mtime.Unix() != int64(1600000000+i):
+                t.Errorf("jobs %d: file%d has time %v", jobs, i, st.Mtime)
+            }
+        }
+    }
+}
+
+// A tree failing every file for being read-only stops after the first
+// few with one message, trying all of them only when told to keep going.
+func TestReadOnlyTree(t *testing.T) {
+    r := newTestRepo(t)
+    files := map[string]string{}
+    for i := 0; i < 8; i++ {
+        files[fmt.Sprintf("f%d", i)] = "1"
+    }
+    r.commit("2020-01-01T00:00:00Z", files)
+
+    run := r.runFailing("*=EROFS")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "working tree appears read-only") {
+        t.Errorf("exit status %d, not stopped as read-only: %v", run.Code, run.Stderr)
+    }
+    if n := strings.Count(run.Stderr, "Error changing file mtime:"); n != readOnlyErrors {
+        t.Errorf("got %d errors before stopping, want %d", n, readOnlyErrors)
+    }
+
+    run = r.runFailing("*=EACCES", "--keep-going")
+    if run.Code != 1 || strings.Contains(run.Stderr, "appears read-only") {
+        t.Errorf("exit status %d, stopped despite --keep-going: %v", run.Code, run.Stderr)
+    }
+    if !strings.Contains(run.Stderr, "Error changing mtime of 8 files") {
+        t.Errorf("not all files tried: %v", run.Stderr)
+    }
+}
+
+// A file of git that is a directory on disk is skipped and counted,
+// the directory keeps its time.
+func TestTypeMismatch(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"thing": "1", "other": "1"})
+    if err := os.Remove(r.path("thing")); err != nil {
+        t.Fatal(err)
+    }
+    r.write("thing/inside", "untracked")
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("thing", now)
+
+    run := r.run()
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !strings.Contains(run.Stderr, "SKIP type mismatch, git has file but disk has directory: thing") || !strings.Contains(run.Stderr, "Type-mismatch skipped: 1") {
+        t.Errorf("mismatch not reported: %v", run.Stderr)
+    }
+    if !r.mtime("thing").Equal(now) {
+        t.Errorf("directory retimed to %v", r.mtime("thing"))
+    }
+    if !r.mtime("other").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("other not retimed")
+    }
+}
+
+// A tracked file missing on disk is skipped by default and fails the
+// run with --fail-missing, listed.
+func TestFailMissing(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"gone": "1", "here": "1"})
+    if err := os.Remove(r.path("gone")); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, args := range [][]string{nil, {"--ignore-missing"}} {
+        run := r.run(args...)
+        if run.Code != 0 || !strings.Contains(run.Stderr, "SKIP not existing file: gone") {
+            t.Errorf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+    }
+    for _, args := range [][]string{{"--fail-missing"}, {"--ignore-missing=false"}} {
+        run := r.run(args...)
+        if run.Code != 1 || !strings.Contains(run.Stderr, "Error 1 tracked files missing on disk:\ngone\n") {
+            t.Errorf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+        if !r.mtime("here").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: files on disk not retimed", args)
+        }
+    }
+}
+
+// Diff of a dry run shows current and new time of files that would
+// change only, and changes none.
+func TestDryRunDiff(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T12:00:00Z", map[string]string{"right": "1", "wrong": "1"})
+    r.setMtime("right", mustTime(t, "2020-01-01T12:00:00Z"))
+    r.setMtime("wrong", mustTime(t, "2024-01-01T12:00:00Z"))
+
+    run := r.run("--dry-run", "--diff")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    lines := strings.Split(strings.TrimSpace(run.Stdout), "\n")
+    if len(lines) != 1 || !strings.HasPrefix(lines[0], "wrong: 2024-01-01") || !strings.Contains(lines[0], " -> 2020-01-01") {
+        t.Errorf("got %q", run.Stdout)
+    }
+    if !r.mtime("wrong").Equal(mustTime(t, "2024-01-01T12:00:00Z")) {
+        t.Errorf("dry run changed time of wrong")
+    }
+}
+
+// Quiet skips leaves out SKIP lines only, retimed files are printed and
+// skipped ones still counted.
+func TestQuietSkips(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"here": "1", "gone": "1"})
+    if err := os.Remove(r.path("gone")); err != nil {
+        t.Fatal(err)
+    }
+    summary := filepath.Join(t.TempDir(), "summary.json")
+
+    run := r.run("--quiet-skips", "--summary-json", summary)
+    if run.Code != 0 || strings.Contains(run.Stderr, "SKIP") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !strings.HasSuffix(run.Stdout, " : here\n") {
+        t.Errorf("retimed file not printed: %q", run.Stdout)
+    }
+    data, err := os.ReadFile(summary)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var counts struct{ Applied, Skipped int }
+    if err := json.Unmarshal(data, &counts); err != nil || counts.Applied != 1 || counts.Skipped != 1 {
+        t.Errorf("got %+v, want 1 applied and 1 skipped: %v", counts, err)
+    }
+
+    if run := r.run(); !strings.Contains(run.Stderr, "SKIP not existing file: gone") {
+        t.Errorf("SKIP line missing without --quiet-skips: %v", run.Stderr)
+    }
+}
+
+// Names of one hard linked file are retimed once, to the newest time of
+// them, and the others counted as deduped.
+func TestDedupeHardlinks(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "same", "c": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"b": "same"})
+    if err := os.Remove(r.path("b")); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Link(r.path("a"), r.path("b")); err != nil {
+        t.Skipf("no hard links here: %v", err)
+    }
+
+    run := r.run("--dedupe-hardlinks")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Hard links deduped: 1") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if n := strings.Count(run.Stdout, " : "); n != 2 {
+        t.Errorf("retimed %d files, want a link once and c: %v", n, run.Stdout)
+    }
+    for f, want := range map[string]string{"a": "2021-01-01T00:00:00Z", "b": "2021-01-01T00:00:00Z", "c": "2020-01-01T00:00:00Z"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, got, want)
+        }
+    }
+}
+
+//------------------------------------------------------------
+// Selecting files
+//------------------------------------------------------------
+
+// Tracked files matching ignore rules are retimed unless told to respect
+// them, then skipped and counted.
+func TestRespectGitignore(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{".gitignore": "*.log\n", "main.go": "1"})
+    r.write("build.log", "1")
+    r.git("add", "-f", "build.log")
+    r.commit("2021-01-01T00:00:00Z", nil)
+    r.write("untracked.log", "1")
+
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("build.log", now)
+    run := r.run("--respect-gitignore")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Ignored 1 files matching ignore rules") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("build.log").Equal(now) {
+        t.Errorf("ignored build.log retimed")
+    }
+    if !r.mtime("main.go").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("main.go not retimed")
+    }
+
+    if run := r.run(); run.Code != 0 || !r.mtime("build.log").Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+        t.Errorf("build.log not retimed by default: %v", run.Stderr)
+    }
+}
+
+// Only files with a time newer than the reference file are retimed, a
+// missing reference is an error.
+func TestSinceFile(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"old": "1", "new": "1"})
+    r.commit("2022-01-01T00:00:00Z", map[string]string{"new": "2"})
+    marker := filepath.Join(t.TempDir(), "last-build")
+    if err := os.WriteFile(marker, nil, 0644); err != nil {
+        t.Fatal(err)
+    }
+    built := mustTime(t, "2021-01-01T00:00:00Z")
+    if err := os.Chtimes(marker, built, built); err != nil {
+        t.Fatal(err)
+    }
+
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("old", now)
+    run := r.run("--since-file", marker)
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Not newer than "+marker+" skipped: 1") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("old").Equal(now) || !r.mtime("new").Equal(mustTime(t, "2022-01-01T00:00:00Z")) {
+        t.Errorf("got old %v and new %v", r.mtime("old"), r.mtime("new"))
+    }
+
+    run = r.run("--since-file", filepath.Join(t.TempDir(), "missing"))
+    if run.Code != 1 || !strings.Contains(run.Stderr, "Error reading reference file") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+}
+
+// Files flagged assume-unchanged or skip-worktree are left alone when
+// told, and again retimed once the flag is cleared.
+func TestSkipLocalFlags(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"assumed": "1", "worktree": "1", "plain": "1"})
+    r.git("update-index", "--assume-unchanged", "assumed")
+    r.git("update-index", "--skip-worktree", "worktree")
+
+    tests := []struct {
+        args []string
+        want []string
+    }{
+        {nil, []string{"assumed", "plain", "worktree"}},
+        {[]string{"--skip-assume-unchanged"}, []string{"plain", "worktree"}},
+        {[]string{"--skip-worktree"}, []string{"assumed", "plain"}},
+        {[]string{"--skip-assume-unchanged", "--skip-worktree"}, []string{"plain"}},
+    }
+    check := func(args, want []string) {
+        t.Helper()
+        run := r.run(append([]string{"--dry-run"}, args...)...)
+        if run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+        got := run.times(t)
+        if len(got) != len(want) {
+            t.Errorf("%v: got %v, want %v", args, got, want)
+        }
+        for _, f := range want {
+            if _, ok := got[f]; !ok {
+                t.Errorf("%v: %v not retimed", args, f)
+            }
+        }
+    }
+    for _, tt := range tests {
+        check(tt.args, tt.want)
+    }
+
+    r.git("update-index", "--no-assume-unchanged", "assumed")
+    check([]string{"--skip-assume-unchanged"}, []string{"assumed", "plain", "worktree"})
+}
+
+// Authors are matched by --author after .mailmap, as git log shows
+// them, so an old address mapped to a new one matches the new.
+func TestAuthorMailmap(t *testing.T) {
+    r := newTestRepo(t)
+    r.write("a", "1")
+    r.write("b", "1")
+    r.write(".mailmap", "Ann Smith <ann@new.example> <ann@old.example>\n")
+    r.git("add", "-A")
+    r.gitAt("2020-01-01T00:00:00Z", "commit", "-q", "--author", "Ann <ann@old.example>", "-m", "by ann")
+    r.write("a", "2")
+    r.gitAt("2021-01-01T00:00:00Z", "commit", "-q", "-a", "--author", "Bob <bob@example>", "-m", "by bob")
+
+    if run := r.run("--author", "ann@new.example"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, f := range []string{"a", "b"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: got %v, want time of mapped author", f, got)
+        }
+    }
+}
+
+// Only files added after a baseline, commit or time, are retimed, files
+// added before it keep their time even if changed since.
+func TestTouchNewOnly(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"old": "1"})
+    r.git("tag", "base")
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"new": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"old": "2"})
+
+    for _, baseline := range []string{"base", "2019-06-01T00:00:00Z"} {
+        now := time.Now().Truncate(time.Second)
+        r.setMtime("old", now)
+        r.setMtime("new", now)
+        run := r.run("--touch-new-only", baseline)
+        if run.Code != 0 || !strings.Contains(run.Stderr, "Added before "+baseline+" skipped: 1") {
+            t.Fatalf("%v: exit status %d: %v", baseline, run.Code, run.Stderr)
+        }
+        if got := r.mtime("new"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: new got %v", baseline, got)
+        }
+        if !r.mtime("old").Equal(now) {
+            t.Errorf("%v: old retimed", baseline)
+        }
+    }
+}
+
+//------------------------------------------------------------
+// Plans
+//------------------------------------------------------------
+
+// A file changed by several commits gets the time of the newest, not
+// of the oldest as when each commit stamped its files in turn.
+func TestNewestCommitWins(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"twice": "1", "once": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"twice": "2"})
+
+    if run := r.run(); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for f, want := range map[string]string{"twice": "2021-01-01T00:00:00Z", "once": "2020-01-01T00:00:00Z"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, got, want)
+        }
+    }
+}
+
+// Files HEAD changed get its time, all others that of its parent, also
+// when HEAD changed none of the paths asked for.
+func TestApproxPlan(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "d/x": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"a": "2", "b": "1"})
+
+    tests := []struct {
+        args []string
+        want map[string]string
+    }{
+        {nil, map[string]string{"a": "2021-01-01T00:00:00Z", "b": "2021-01-01T00:00:00Z", "d/x": "2020-01-01T00:00:00Z"}},
+        {[]string{"--path", "a"}, map[string]string{"a": "2021-01-01T00:00:00Z"}},
+        {[]string{"--path", "d"}, map[string]string{"d/x": "2020-01-01T00:00:00Z"}},
+    }
+    for _, tt := range tests {
+        run := r.run(append([]string{"--dry-run", "--approx"}, tt.args...)...)
+        if run.Code != 0 {
+            t.Errorf("%v: exit status %d: %v", tt.args, run.Code, run.Stderr)
+            continue
+        }
+        got := run.times(t)
+        if len(got) != len(tt.want) {
+            t.Errorf("%v: got %v, want %v", tt.args, got, tt.want)
+        }
+        for f, date := range tt.want {
+            if !got[f].Equal(mustTime(t, date)) {
+                t.Errorf("%v: %v got %v, want %v", tt.args, f, got[f], date)
+            }
+        }
+    }
+}
+
+// Replaces changing file times with recording calls for the rest of
+// the test. Files are still retimed.
+func countChangeTimes(t *testing.T) (calls *[]string) {
+    calls = &[]string{}
+    saved := changeTimes
+    changeTimes = func(fpath string, atime, mtime time.Time, nofollow bool) error {
+        *calls = append(*calls, filepath.Base(fpath))
+        return saved(fpath, atime, mtime, nofollow)
+    }
+    t.Cleanup(func() { changeTimes = saved })
+    return
+}
+
+// Plan of files in root, made on disk with the times given, each with
+// its wanted time.
+func diskPlan(t *testing.T, root string, files []string, current, wanted []time.Time) (plan []planEntry, modes map[string]string) {
+    t.Helper()
+    modes = map[string]string{}
+    for i, f := range files {
+        fpath := filepath.Join(root, f)
+        if err := os.WriteFile(fpath, nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(fpath, current[i], current[i]); err != nil {
+            t.Fatal(err)
+        }
+        plan = append(plan, planEntry{Path: f, Mtime: wanted[i]})
+        modes[f] = "100644"
+    }
+    return
+}
+
+// --minimal changes times of files not at their time and makes no call
+// at all for files already at it.
+func TestMinimalSkipsCorrectFiles(t *testing.T) {
+    root := t.TempDir()
+    old, want := time.Unix(1500000000, 0), time.Unix(1600000000, 0)
+    plan, modes := diskPlan(t, root, []string{"correct", "stale"}, []time.Time{want, old}, []time.Time{want, want})
+    calls := countChangeTimes(t)
+
+    opts := Options{SkipUnchanged: true, NoDereference: true, KeepAtime: true, QuietSkips: true, TimeFormat: "rfc3339"}
+    stats, err := applyPlan(root, plan, modes, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(*calls, []string{"stale"}) {
+        t.Errorf("times changed for %v, want stale only", *calls)
+    }
+    if stats.Applied != 1 || stats.Unchanged != 1 {
+        t.Errorf("applied %d and unchanged %d, want 1 each", stats.Applied, stats.Unchanged)
+    }
+
+    // Run again, every file is now correct
+    *calls = nil
+    if _, err := applyPlan(root, plan, modes, opts); err != nil {
+        t.Fatal(err)
+    }
+    if len(*calls) != 0 {
+        t.Errorf("second run changed times for %v", *calls)
+    }
+}
+
+// --minimal from the command line keeps access times and leaves files
+// already at their time alone.
+func TestMinimalRun(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    if run := r.run("--minimal"); run.Code != 0 || !strings.Contains(run.Stdout, ": a") {
+        t.Fatalf("exit status %d, a not retimed: %v%v", run.Code, run.Stdout, run.Stderr)
+    }
+    if got := r.mtime("a"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("got %v", got)
+    }
+
+    run := r.run("--minimal", "--verbose")
+    if run.Code != 0 || strings.Contains(run.Stdout, ": a") {
+        t.Errorf("second run retimed a: %v", run.Stdout)
+    }
+}
+
+// --newer-only leaves alone a file edited after it was stat'ed, though
+// its planned time is newer than both.
+func TestNewerOnlyRecheck(t *testing.T) {
+    root := t.TempDir()
+    old, want := time.Unix(1500000000, 0), time.Unix(1600000000, 0)
+    edited := old.Add(time.Hour)
+    plan, modes := diskPlan(t, root, []string{"a", "b"}, []time.Time{old, old}, []time.Time{want, want})
+
+    // Edit b while a is retimed, after the stat of both
+    saved := changeTimes
+    changeTimes = func(fpath string, atime, mtime time.Time, nofollow bool) error {
+        if filepath.Base(fpath) == "a" {
+            os.Chtimes(filepath.Join(root, "b"), edited, edited)
+        }
+        return saved(fpath, atime, mtime, nofollow)
+    }
+    t.Cleanup(func() { changeTimes = saved })
+
+    opts := Options{NewerOnly: true, NoDereference: true, QuietSkips: true, TimeFormat: "rfc3339"}
+    stats, err := applyPlan(root, plan, modes, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stats.Applied != 1 || stats.Raced != 1 {
+        t.Errorf("applied %d and raced %d, want 1 each", stats.Applied, stats.Raced)
+    }
+    disk := &testRepo{t: t, Dir: root}
+    if got := disk.mtime("a"); !got.Equal(want) {
+        t.Errorf("a: got %v, want %v", got, want)
+    }
+    if got := disk.mtime("b"); !got.Equal(edited) {
+        t.Errorf("b: got %v, want %v as edited", got, edited)
+    }
+}
+
+// Missing or corrupt index while HEAD has files is an error of its own
+// unless told to rebuild it, a repository without files is not.
+func TestCheckIndex(t *testing.T) {
+    tests := []struct {
+        name  string
+        index func(r *testRepo)
+        args  []string
+        code  int
+        say   string
+    }{
+        {"missing", func(r *testRepo) { os.Remove(filepath.Join(r.Dir, ".git", "index")) }, nil, exitBadIndex, "missing or empty"},
+        {"corrupt", func(r *testRepo) { os.WriteFile(filepath.Join(r.Dir, ".git", "index"), []byte("garbage"), 0644) }, nil, exitBadIndex, "corrupt"},
+        {"rebuilt", func(r *testRepo) { os.Remove(filepath.Join(r.Dir, ".git", "index")) }, []string{"--on-missing-git", "rebuild-index"}, 0, "rebuilding it from HEAD"},
+        {"intact", func(r *testRepo) {}, nil, 0, ""},
+    }
+    for _, tt := range tests {
+        r := newTestRepo(t)
+        r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+        tt.index(r)
+
+        run := r.run(tt.args...)
+        if run.Code != tt.code || !strings.Contains(run.Stderr, tt.say) {
+            t.Errorf("%v: exit status %d, want %d saying %q: %v", tt.name, run.Code, tt.code, tt.say, run.Stderr)
+        }
+        if tt.code == exitBadIndex && !strings.Contains(run.Stderr, "git read-tree HEAD") {
+            t.Errorf("%v: no hint how to fix: %v", tt.name, run.Stderr)
+        }
+        if tt.code == 0 && !r.mtime("a").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: a not retimed", tt.name)
+        }
+    }
+
+    // HEAD without files has nothing to list
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", nil)
+    if run := r.run(); run.Code != 0 {
+        t.Errorf("empty tree: exit status %d: %v", run.Code, run.Stderr)
+    }
+}
+
+// All tracked files missing from disk is told apart from nothing to do,
+// some missing is not.
+func TestAllFilesMissing(t *testing.T) {
+    tests := []struct {
+        name   string
+        remove []string
+        args   []string
+        code   int
+    }{
+        {"all", []string{"a", "d/b"}, nil, exitAllMissing},
+        {"all dry run", []string{"a", "d/b"}, []string{"--dry-run"}, exitAllMissing},
+        {"some", []string{"a"}, nil, 0},
+        {"none", nil, nil, 0},
+    }
+    for _, tt := range tests {
+        r := newTestRepo(t)
+        r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "d/b": "1"})
+        for _, f := range tt.remove {
+            if err := os.Remove(r.path(f)); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        run := r.run(tt.args...)
+        said := strings.Contains(run.Stderr, "all 2 tracked files are missing from the working tree")
+        if run.Code != tt.code || said != (tt.code == exitAllMissing) {
+            t.Errorf("%v: exit status %d, want %d: %v", tt.name, run.Code, tt.code, run.Stderr)
+        }
+    }
+}
+
+// Commits listed are those giving at least one file its time, with the
+// number of such files, a commit whose files all changed again later
+// left out.
+func TestPrintCommits(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1"})
+    first := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+    r.commit("2020-02-01T00:00:00Z", map[string]string{"a": "2"})
+    r.commit("2020-03-01T00:00:00Z", map[string]string{"a": "3", "b": "2"})
+    third := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+
+    run := r.run("--print-commits", "--format", "json")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    got := map[string]planCommit{}
+    for _, line := range strings.Split(strings.TrimSpace(run.Stdout), "\n") {
+        var c planCommit
+        if err := json.Unmarshal([]byte(line), &c); err != nil {
+            t.Fatalf("bad commit %q: %v", line, err)
+        }
+        got[c.Commit] = c
+    }
+
+    want := map[string]planCommit{
+        first: {Commit: first, Mtime: mustTime(t, "2020-01-01T00:00:00Z"), Files: 1},
+        third: {Commit: third, Mtime: mustTime(t, "2020-03-01T00:00:00Z"), Files: 2},
+    }
+    if len(got) != len(want) {
+        t.Errorf("got commits %v, want first and third only", got)
+    }
+    for hash, w := range want {
+        if g := got[hash]; g.Files != w.Files || !g.Mtime.Equal(w.Mtime) {
+            t.Errorf("%v: got %+v, want %+v", hash, g, w)
+        }
+    }
+}
+
+// Until first applied retimes one file of those needing it, leaving the
+// others as they were.
+func TestUntilFirstApplied(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1"})
+    now := time.Now().Truncate(time.Second)
+    for _, f := range []string{"a", "b", "c"} {
+        r.setMtime(f, now)
+    }
+
+    run := r.run("--until-first-applied")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Stopped after first file retimed") {
+        t.Fatalf("exit status %d, not stopped: %v", run.Code, run.Stderr)
+    }
+    retimed := 0
+    for _, f := range []string{"a", "b", "c"} {
+        switch got := r.mtime(f); {
+        case got.Equal(mustTime(t, "2020-01-01T00:00:00Z")):
+            retimed++
+        case !got.Equal(now):
+            t.Errorf("%v: got %v", f, got)
+        }
+    }
+    if retimed != 1 {
+        t.Errorf("retimed %d files, want 1", retimed)
+    }
+}
+
+// Pathspecs too many for one command line give the same times as few,
+// with commits changing files of some batches of them only.
+func TestManyPathspecs(t *testing.T) {
+    r := newTestRepo(t)
+    files := map[string]string{}
+    var paths, args []string
+    for i := 0; i < 500; i++ {
+        f := fmt.Sprintf("directory-with-a-name-long-enough-to-fill-command-lines/file-%04d", i)
+        files[f] = "1"
+        paths = append(paths, f)
+        args = append(args, "--path", f)
+    }
+    if len(pathspecBatches(paths)) < 2 {
+        t.Fatal("pathspecs fit one command line")
+    }
+    first := "directory-with-a-name-long-enough-to-fill-command-lines/file-0000"
+    last := "directory-with-a-name-long-enough-to-fill-command-lines/file-0499"
+    r.commit("2020-01-01T00:00:00Z", files)
+    r.commit("2021-01-01T00:00:00Z", map[string]string{last: "2"})
+    r.commit("2022-01-01T00:00:00Z", map[string]string{first: "2"})
+
+    tests := []struct {
+        mode               string
+        first, last, other string
+    }{
+        {"--verbose", "2022-01-01T00:00:00Z", "2021-01-01T00:00:00Z", "2020-01-01T00:00:00Z"},
+        {"--stream", "2022-01-01T00:00:00Z", "2021-01-01T00:00:00Z", "2020-01-01T00:00:00Z"},
+        // Files not of HEAD get time of its parent
+        {"--approx", "2022-01-01T00:00:00Z", "2021-01-01T00:00:00Z", "2021-01-01T00:00:00Z"},
+    }
+    for _, tt := range tests {
+        now := time.Now().Truncate(time.Second)
+        for f := range files {
+            r.setMtime(f, now)
+        }
+        if run := r.run(append([]string{tt.mode}, args...)...); run.Code != 0 {
+            t.Errorf("%v: exit status %d: %v", tt.mode, run.Code, run.Stderr)
+            continue
+        }
+        for f, want := range map[string]string{first: tt.first, last: tt.last, "directory-with-a-name-long-enough-to-fill-command-lines/file-0250": tt.other} {
+            if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+                t.Errorf("%v: %v: got %v, want %v", tt.mode, f, got, want)
+            }
+        }
+    }
+}
+
+// Times are rounded to the nearest multiple of the duration, halves
+// away from zero, as time.Round does.
+func TestRoundPlan(t *testing.T) {
+    tests := []struct {
+        round    time.Duration
+        in, want string
+    }{
+        {2 * time.Second, "2020-01-01T10:00:01Z", "2020-01-01T10:00:02Z"},
+        {2 * time.Second, "2020-01-01T10:00:02Z", "2020-01-01T10:00:02Z"},
+        {2 * time.Second, "2020-01-01T10:00:03Z", "2020-01-01T10:00:04Z"},
+        {time.Minute, "2020-01-01T10:00:29Z", "2020-01-01T10:00:00Z"},
+        {time.Minute, "2020-01-01T10:00:30Z", "2020-01-01T10:01:00Z"},
+        {time.Hour, "2020-01-01T10:59:59Z", "2020-01-01T11:00:00Z"},
+    }
+    for _, tt := range tests {
+        plan := []planEntry{{Path: "f", Mtime: mustTime(t, tt.in)}}
+        roundPlan(plan, tt.round)
+        if !plan[0].Mtime.Equal(mustTime(t, tt.want)) {
+            t.Errorf("%v rounded to %v: got %v, want %v", tt.in, tt.round, plan[0].Mtime, tt.want)
+        }
+    }
+
+    r := newTestRepo(t)
+    r.commit("2020-01-01T10:00:45Z", map[string]string{"f": "1"})
+    if run := r.run("--round", "1m"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if got := r.mtime("f"); !got.Equal(mustTime(t, "2020-01-01T10:01:00Z")) {
+        t.Errorf("got %v", got)
+    }
+}
+
+// Out of time, the walk stops with the files resolved so far, each with
+// its final time.
+func TestMaxRuntime(t *testing.T) {
+    h := newFakeHistory(200, 80)
+    h.install(t)
+    // Slow git, a millisecond per command
+    gitRunner = func(stdin []byte, args ...string) (stdout, stderr []byte, err error) {
+        time.Sleep(time.Millisecond)
+        return h.run(stdin, args...)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    plan, err := buildPlan(ctx, nil, h.tracked(), nil, "log", false)
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want deadline exceeded", err)
+    }
+    if len(plan) == 0 || len(plan) == len(h.newest) {
+        t.Errorf("got %d of %d files, want some", len(plan), len(h.newest))
+    }
+    for _, e := range plan {
+        if want := h.dates[h.newest[e.Path]]; !e.Mtime.Equal(want) {
+            t.Errorf("%v: got %v, want %v", e.Path, e.Mtime, want)
+        }
+    }
+
+    // Reported apart, with an exit status of its own
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    run := r.run("--max-runtime", "1ns")
+    if run.Code != 3 || !strings.Contains(run.Stderr, "Stopped early") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+}
+
+// Component granularity gives all files of a top level directory the
+// newest time among them, files deleted from it not counting. Top
+// level files keep their own, directories aren't touched.
+func TestComponentGranularity(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2018-01-01T00:00:00Z", map[string]string{"top": "1", "lib/gone": "1"})
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"app/c": "1"})
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"lib/a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"lib/sub/b": "1"})
+    r.git("rm", "-q", "lib/gone")
+    r.commit("2022-01-01T00:00:00Z", nil)
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("lib", now)
+
+    if run := r.run("--granularity", "component"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for f, want := range map[string]string{"lib/a": "2021-01-01T00:00:00Z", "lib/sub/b": "2021-01-01T00:00:00Z", "app/c": "2019-01-01T00:00:00Z", "top": "2018-01-01T00:00:00Z"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, got, want)
+        }
+    }
+    if !r.mtime("lib").Equal(now) {
+        t.Errorf("directory lib retimed")
+    }
+}
+
+// Directories are listed with the newest time of files in them, grouped
+// by as many levels as depth tells, in path order. Nothing is retimed.
+func TestPrintNewestPerDir(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2018-01-01T00:00:00Z", map[string]string{"top": "1"})
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"app/c": "1"})
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"lib/a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"lib/sub/b": "1"})
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("top", now)
+
+    tests := []struct {
+        depth string
+        want  []string // Directory and its time
+    }{
+        {"1", []string{"./", "2018-01-01T00:00:00Z", "app/", "2019-01-01T00:00:00Z", "lib/", "2021-01-01T00:00:00Z"}},
+        {"2", []string{"./", "2018-01-01T00:00:00Z", "app/", "2019-01-01T00:00:00Z", "lib/", "2020-01-01T00:00:00Z", "lib/sub/", "2021-01-01T00:00:00Z"}},
+    }
+    for _, tt := range tests {
+        run := r.run("--print-newest-per-dir", "--depth", tt.depth, "--format", "json")
+        if run.Code != 0 {
+            t.Fatalf("depth %v: exit status %d: %v", tt.depth, run.Code, run.Stderr)
+        }
+        var got []string
+        for _, line := range strings.Split(strings.TrimSpace(run.Stdout), "\n") {
+            var e planEntry
+            if err := json.Unmarshal([]byte(line), &e); err != nil {
+                t.Fatalf("depth %v: bad record %q: %v", tt.depth, line, err)
+            }
+            got = append(got, e.Path, e.Mtime.UTC().Format(time.RFC3339))
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("depth %v: got %v, want %v", tt.depth, got, tt.want)
+        }
+    }
+    if !r.mtime("top").Equal(now) {
+        t.Errorf("report retimed a file")
+    }
+}
+
+// Verbose run prints size and time range of the plan before applying.
+func TestPlanSummary(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"c": "1"})
+    r.commit("2022-01-01T00:00:00Z", map[string]string{"b": "2"})
+
+    run := r.run("--verbose")
+    want := "Plan: 3 files from 3 commits, 2020-01-01T00:00:00Z to 2022-01-01T00:00:00Z\n"
+    if run.Code != 0 || !strings.Contains(run.Stderr, want) {
+        t.Errorf("exit status %d: %q, want %q", run.Code, run.Stderr, want)
+    }
+    if run := r.run(); strings.Contains(run.Stderr, "Plan: ") {
+        t.Errorf("summary without --verbose: %v", run.Stderr)
+    }
+}
+
+// Aligned to commit order, a file of a commit dated before its parent
+// takes the parent's time, times in order are kept.
+func TestAlignToCommitOrder(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"skewed": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"c": "1"})
+
+    want := map[string]string{"a": "2020-01-01T00:00:00Z", "skewed": "2019-01-01T00:00:00Z", "c": "2021-01-01T00:00:00Z"}
+    for _, align := range []bool{false, true} {
+        args := []string{}
+        if align {
+            args = append(args, "--align-to-commit-order")
+            want["skewed"] = "2020-01-01T00:00:00Z"
+        }
+        run := r.run(args...)
+        if run.Code != 0 {
+            t.Fatalf("align %v: exit status %d: %v", align, run.Code, run.Stderr)
+        }
+        if align && !strings.Contains(run.Stderr, "Aligned to commit order: 1 files") {
+            t.Errorf("got %v", run.Stderr)
+        }
+        for f, w := range want {
+            if got := r.mtime(f); !got.Equal(mustTime(t, w)) {
+                t.Errorf("align %v: %v got %v, want %v", align, f, got, w)
+            }
+        }
+    }
+}
+
+// With --index, staged files, new ones too, get the index time, now if
+// not given. Unstaged edits and untouched files keep committed times.
+func TestIndexTime(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"staged": "1", "unstaged": "1", "same": "1"})
+    r.write("staged", "2")
+    r.write("new", "1")
+    r.git("add", "staged", "new")
+    r.write("unstaged", "2")
+
+    run := r.run("--index", "--index-time", "2023-01-01T00:00:00Z")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Staged files given index time: 2") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    want := map[string]string{"staged": "2023-01-01T00:00:00Z", "new": "2023-01-01T00:00:00Z", "unstaged": "2020-01-01T00:00:00Z", "same": "2020-01-01T00:00:00Z"}
+    for f, w := range want {
+        if got := r.mtime(f); !got.Equal(mustTime(t, w)) {
+            t.Errorf("%v got %v, want %v", f, got, w)
+        }
+    }
+
+    start := time.Now().Truncate(time.Second)
+    if run := r.run("--index"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, f := range []string{"staged", "new"} {
+        if got := r.mtime(f); got.Before(start) {
+            t.Errorf("%v got %v, want now", f, got)
+        }
+    }
+}
+
+// By release tag, files get the time of the first tag containing their
+// last commit, of tagging if annotated, unreleased ones their own.
+func TestByReleaseTag(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2019-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2019-06-01T00:00:00Z", map[string]string{"b": "1"})
+    r.commit("2019-09-01T00:00:00Z", map[string]string{"d": "1"})
+    r.git("tag", "v1")
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "2"})
+    r.gitAt("2020-06-01T00:00:00Z", "tag", "-a", "-m", "release", "v2")
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"c": "1"})
+
+    run := r.run("--by-release-tag")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Release times of 3 files, 1 unreleased keep commit time") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    want := map[string]string{"a": "2020-06-01T00:00:00Z", "b": "2019-09-01T00:00:00Z", "d": "2019-09-01T00:00:00Z", "c": "2021-01-01T00:00:00Z"}
+    for f, w := range want {
+        if got := r.mtime(f); !got.Equal(mustTime(t, w)) {
+            t.Errorf("%v got %v, want %v", f, got, w)
+        }
+    }
+}
+
+// Debug spread gives files made up times a step apart in path order,
+// warning they aren't times of history.
+func TestDebugSpread(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"c": "1", "a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"b/x": "1"})
+
+    run := r.run("--debug-spread", "1h")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "WARNING --debug-spread gives made up times 1h0m0s apart from 2000-01-01T00:00:00Z") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    want := map[string]string{"a": "2000-01-01T00:00:00Z", "b/x": "2000-01-01T01:00:00Z", "c": "2000-01-01T02:00:00Z"}
+    for f, w := range want {
+        if got := r.mtime(f); !got.Equal(mustTime(t, w)) {
+            t.Errorf("%v got %v, want %v", f, got, w)
+        }
+    }
+}
+
+// A plan smaller than asked for is refused with its size, unless forced
+// or only a dry run.
+func TestMinPlanSize(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1"})
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("a", now)
+
+    run := r.run("--apply-only-if-plan-size-at-least", "1000")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "Refusing to apply, plan has 2 files, fewer than --apply-only-if-plan-size-at-least 1000") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("a").Equal(now) {
+        t.Errorf("refused plan applied")
+    }
+
+    if run := r.run("--apply-only-if-plan-size-at-least", "1000", "--dry-run"); run.Code != 0 {
+        t.Errorf("dry run: exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, args := range [][]string{
+        {"--apply-only-if-plan-size-at-least", "1000", "--force"},
+        {"--apply-only-if-plan-size-at-least", "2"},
+    } {
+        r.setMtime("a", now)
+        if run := r.run(args...); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+        if got := r.mtime("a"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: a got %v", args, got)
+        }
+    }
+}
+
+//------------------------------------------------------------
+// Repository layouts
+//------------------------------------------------------------
+
+// A shallow clone is refused with a warning unless allowed, as files
+// last changed before the boundary would get its time.
+func TestShallowClone(t *testing.T) {
+    origin := newTestRepo(t)
+    origin.commit("2020-01-01T00:00:00Z", map[string]string{"old": "1", "new": "1"})
+    origin.commit("2021-01-01T00:00:00Z", map[string]string{"new": "2"})
+    r := &testRepo{t: t, Dir: filepath.Join(t.TempDir(), "shallow")}
+    origin.git("clone", "-q", "--depth", "1", "file://"+origin.Dir, r.Dir)
+
+    run := r.run()
+    if run.Code != 1 || !strings.Contains(run.Stderr, "WARNING shallow clone") || !strings.Contains(run.Stderr, "--allow-shallow") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+
+    run = r.run("--allow-shallow", "--dry-run")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "WARNING shallow clone") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    // Boundary commit has all files
+    if got := run.times(t)["old"]; !got.Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+        t.Errorf("old got %v, want time of the boundary", got)
+    }
+}
+
+// With core.worktree the work tree is where config says, not around
+// the git directory, and all its files are retimed run from anywhere
+// in it.
+func TestCoreWorktree(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "d/b": "1"})
+
+    // Git directory moved away, config pointing back to the tree
+    gitDir := filepath.Join(t.TempDir(), "repo.git")
+    if err := os.Rename(filepath.Join(r.Dir, ".git"), gitDir); err != nil {
+        t.Fatal(err)
+    }
+    r.git("--git-dir", gitDir, "config", "core.worktree", r.Dir)
+
+    run := runGitimeEnv(t, r.path("d"), "", []string{"GIT_DIR=" + gitDir})
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, f := range []string{"a", "d/b"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: got %v", f, got)
+        }
+    }
+}
+
+// Run from a subdirectory, git paths are taken from the top of the work
+// tree, not the working directory, and the whole tree is retimed.
+func TestRunFromSubdirectory(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"top": "1", "src/a": "1", "src/deep/b": "1"})
+    // Same names under the subdirectory, wrongly joined paths hit them
+    r.write("src/top", "untracked")
+    r.write("src/src/a", "untracked")
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("src/top", now)
+    r.setMtime("src/src/a", now)
+
+    run := runGitime(t, r.path("src"), "")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, f := range []string{"top", "src/a", "src/deep/b"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: got %v", f, got)
+        }
+    }
+    for _, f := range []string{"src/top", "src/src/a"} {
+        if !r.mtime(f).Equal(now) {
+            t.Errorf("untracked %v retimed", f)
+        }
+    }
+}
+
+// Commit-graph is written once when asked and missing, never by a git
+// too old to have it.
+func TestWriteCommitGraph(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.enter()
+    saved := gitVersionSeen
+    t.Cleanup(func() { gitVersionSeen = saved })
+
+    gitVersionSeen = "2.17.0"
+    ensureCommitGraph()
+    if has, err := gitHasCommitGraph(); err != nil || has {
+        t.Fatalf("old git wrote commit-graph: %v", err)
+    }
+
+    gitVersionSeen = ""
+    if !gitSupports("commit-graph") {
+        t.Skip("git has no commit-graph")
+    }
+    run := r.run("--write-commit-graph")
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Writing commit-graph") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if has, err := gitHasCommitGraph(); err != nil || !has {
+        t.Errorf("no commit-graph written: %v", err)
+    }
+    if run := r.run("--write-commit-graph"); strings.Contains(run.Stderr, "Writing commit-graph") {
+        t.Errorf("commit-graph written again: %v", run.Stderr)
+    }
+}
+
+// A branch without commits says there is nothing to take times from and
+// succeeds, staged files left alone, in a new repository as on an
+// orphan branch.
+func TestNoCommitsYet(t *testing.T) {
+    r := newTestRepo(t)
+    check := func(name string) {
+        t.Helper()
+        r.write("staged", "1")
+        r.git("add", "staged")
+        now := time.Now().Truncate(time.Second)
+        r.setMtime("staged", now)
+
+        run := r.run()
+        if run.Code != 0 || !strings.Contains(run.Stderr, "No commits yet on this branch") || !strings.Contains(run.Stderr, "commit files first") {
+            t.Errorf("%v: exit status %d: %v", name, run.Code, run.Stderr)
+        }
+        if !r.mtime("staged").Equal(now) {
+            t.Errorf("%v: staged file retimed", name)
+        }
+    }
+    check("new repository")
+
+    r.commit("2020-01-01T00:00:00Z", nil)
+    r.git("checkout", "-q", "--orphan", "fresh")
+    check("orphan branch")
+}
+
+// A symlinked directory above the tree, as working directory or --root,
+// leads to the real files, while a tracked symlink is still followed to
+// its target.
+func TestSymlinkedAncestor(t *testing.T) {
+    r := newTestRepo(t)
+    if err := os.Symlink("target", r.path("link")); err != nil {
+        t.Fatal(err)
+    }
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "d/b": "1"})
+    r.write("target", "untracked")
+    via := filepath.Join(t.TempDir(), "via")
+    if err := os.Symlink(r.Dir, via); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, args := range [][]string{nil, {"--root", via}} {
+        now := time.Now().Truncate(time.Second)
+        for _, f := range []string{"a", "d/b", "target"} {
+            r.setMtime(f, now)
+        }
+        if run := runGitime(t, via, "", args...); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", args, run.Code, run.Stderr)
+        }
+        for _, f := range []string{"a", "d/b"} {
+            if got := r.mtime(f); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+                t.Errorf("%v: %v got %v", args, f, got)
+            }
+        }
+        if got := r.mtime("target"); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+            t.Errorf("%v: target got %v", args, got)
+        }
+        if fi, err := os.Stat(via); err != nil || !fi.IsDir() {
+            t.Errorf("%v: symlink above the tree broken: %v", args, err)
+        }
+    }
+}
+
+// With .git a directory or, in a linked worktree, a file pointing
+// elsewhere, the git directory is found and files are retimed.
+func TestGitDirOrFile(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    linked := &testRepo{t: t, Dir: filepath.Join(t.TempDir(), "linked")}
+    r.git("worktree", "add", "-q", "-b", "other", linked.Dir)
+    linked.commit("2021-01-01T00:00:00Z", map[string]string{"b": "1"})
+
+    for _, tt := range []struct {
+        name string
+        r    *testRepo
+        want map[string]string
+    }{
+        {"directory", r, map[string]string{"a": "2020-01-01T00:00:00Z"}},
+        {"file", linked, map[string]string{"a": "2020-01-01T00:00:00Z", "b": "2021-01-01T00:00:00Z"}},
+    } {
+        fi, err := os.Stat(tt.r.path(".git"))
+        if err != nil || fi.IsDir() != (tt.name == "directory") {
+            t.Fatalf("%v: .git not a %v: %v", tt.name, tt.name, err)
+        }
+        tt.r.enter()
+        gitDir, err := gitAbsoluteDir()
+        if err != nil {
+            t.Fatalf("%v: %v", tt.name, err)
+        }
+        if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); err != nil {
+            t.Errorf("%v: git directory %v: %v", tt.name, gitDir, err)
+        }
+
+        if run := tt.r.run(); run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.name, run.Code, run.Stderr)
+        }
+        for f, want := range tt.want {
+            if got := tt.r.mtime(f); !got.Equal(mustTime(t, want)) {
+                t.Errorf("%v: %v got %v, want %v", tt.name, f, got, want)
+            }
+        }
+    }
+}
+
+// An uninitialized submodule, a gitlink with an empty directory, is
+// skipped unless asked to be retimed to the time of its gitlink.
+func TestGitlinks(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+    r.git("update-index", "--add", "--cacheinfo", "160000,"+head+",sub")
+    r.gitAt("2021-01-01T00:00:00Z", "commit", "-q", "-m", "add submodule")
+    if err := os.Mkdir(r.path("sub"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("sub", now)
+
+    run := r.run()
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Submodules skipped: 1") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !r.mtime("sub").Equal(now) {
+        t.Errorf("submodule retimed")
+    }
+
+    if run := r.run("--include-gitlinks-as-files"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if got := r.mtime("sub"); !got.Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+        t.Errorf("submodule got %v", got)
+    }
+}
@@ -0,0 +1,3 @@
+module github.com/deze333/gitime
+
+go 1.21
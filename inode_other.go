@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Identifies the file behind a name, same for all its hard links.
+// No inode info here, every name is its own file.
+func fileID(fi os.FileInfo) (id inodeKey, ok bool) {
+    return
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// Identifies the file behind a name, same for all its hard links.
+func fileID(fi os.FileInfo) (id inodeKey, ok bool) {
+    st, ok := fi.Sys().(*syscall.Stat_t)
+    if !ok {
+        return
+    }
+    return inodeKey{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+    "os"
+    "syscall"
+    "time"
+    "unsafe"
+)
+
+// Constants of utimensat, see fcntl.h and stat.h.
+const (
+    atFdcwd           = -0x64
+    atSymlinkNofollow = 0x100
+    utimeOmit         = (1 << 30) - 2 // Leaves a time as it is
+)
+
+// Changes times of file, of a symlink itself rather than its target.
+// Zero time is left unchanged, as with Chtimes.
+func lchtimes(fpath string, atime, mtime time.Time) error {
+    p, err := syscall.BytePtrFromString(fpath)
+    if err != nil {
+        return err
+    }
+    var ts [2]syscall.Timespec
+    for i, t := range []time.Time{atime, mtime} {
+        if t.IsZero() {
+            ts[i] = syscall.Timespec{Nsec: utimeOmit}
+        } else {
+            ts[i] = syscall.NsecToTimespec(t.UnixNano())
+        }
+    }
+
+    dirfd := atFdcwd
+    _, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+        uintptr(unsafe.Pointer(&ts[0])), atSymlinkNofollow, 0, 0)
+    if errno != 0 {
+        return &os.PathError{Op: "utimensat", Path: fpath, Err: errno}
+    }
+    return nil
+}
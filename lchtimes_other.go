@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+    "errors"
+    "os"
+    "time"
+)
+
+// Changes times of file, which can't be done for a symlink itself here.
+// Zero time is left unchanged, as with Chtimes.
+func lchtimes(fpath string, atime, mtime time.Time) error {
+    fi, err := os.Lstat(fpath)
+    if err != nil {
+        return err
+    }
+    if fi.Mode()&os.ModeSymlink != 0 {
+        return errors.New("changing times of symlinks themselves is only supported on Linux")
+    }
+    return os.Chtimes(fpath, atime, mtime)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "time"
+)
+
+//------------------------------------------------------------
+// Keeping parallel runs on one work tree apart
+//------------------------------------------------------------
+
+// Lock file name, in the git directory like git's own locks.
+const lockName = "gitime.lock"
+
+// How often a waiting run checks the lock again.
+const lockPoll = 100 * time.Millisecond
+
+// Path of lock file held by this run, if any.
+// Guarded as the signal handler may release it any time.
+var (
+    lockPath string
+    lockMu   sync.Mutex
+)
+
+// Takes the advisory lock of the repository, waiting for another run
+// holding it to finish if told so. A lock left by a killed run must be
+// removed by hand, it holds the process id that took it.
+// The lock is released on interrupt or termination too.
+func acquireLock(gitDir string, wait bool) (err error) {
+    fpath := filepath.Join(gitDir, lockName)
+    waiting := false
+    for {
+        f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+        if err == nil {
+            fmt.Fprintf(f, "%d\n", os.Getpid())
+            if err = f.Close(); err != nil {
+                os.Remove(fpath)
+                return err
+            }
+            break
+        }
+        if !os.IsExist(err) {
+            return err
+        }
+        if !wait {
+            return fmt.Errorf("another gitime is running on this tree, remove %v if not", fpath)
+        }
+        if !waiting {
+            fmt.Fprintf(os.Stderr, "Waiting for another gitime to finish, remove %v if none runs\n", fpath)
+            waiting = true
+        }
+        time.Sleep(lockPoll)
+    }
+    lockMu.Lock()
+    lockPath = fpath
+    lockMu.Unlock()
+
+    sigs := make(chan os.Signal, 1)
+    signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        sig := <-sigs
+        releaseLock()
+        fmt.Fprintf(os.Stderr, "Stopped by %v\n", sig)
+        os.Exit(1)
+    }()
+    return
+}
+
+// Releases the lock if held.
+func releaseLock() {
+    lockMu.Lock()
+    defer lockMu.Unlock()
+    if lockPath == "" {
+        return
+    }
+    os.Remove(lockPath)
+    lockPath = ""
+}
+
+// Exits with given code, releasing the lock first.
+func exit(code int) {
+    releaseLock()
+    os.Exit(code)
+}
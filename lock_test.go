@@ -0,0 +1,59 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Lock is held by one run at a time, a second one fails without
+// waiting, and taking it again works once released.
+func TestAcquireLock(t *testing.T) {
+    dir := t.TempDir()
+    fpath := filepath.Join(dir, lockName)
+    if err := acquireLock(dir, false); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(fpath); err != nil {
+        t.Errorf("lock file not made: %v", err)
+    }
+    if err := acquireLock(dir, false); err == nil {
+        t.Errorf("lock taken twice")
+    }
+
+    releaseLock()
+    if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+        t.Errorf("lock file left after release: %v", err)
+    }
+    if err := acquireLock(dir, false); err != nil {
+        t.Errorf("lock not taken again after release: %v", err)
+    }
+    releaseLock()
+}
+
+// Run failing once locked leaves no lock behind, a run finding the lock
+// held fails at once with no wait.
+func TestLockRun(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    fpath := filepath.Join(r.Dir, ".git", lockName)
+
+    if run := r.run("--from-commit", "no-such-commit"); run.Code != 1 {
+        t.Errorf("exit status %d of failing run: %v", run.Code, run.Stderr)
+    }
+    if _, err := os.Stat(fpath); !os.IsNotExist(err) {
+        t.Errorf("lock file left by failed run: %v", err)
+    }
+
+    if err := os.WriteFile(fpath, []byte("1\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    run := r.run("--no-wait")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "another gitime is running") {
+        t.Errorf("exit status %d with lock held: %v", run.Code, run.Stderr)
+    }
+    if _, err := os.Stat(fpath); err != nil {
+        t.Errorf("lock of other run removed: %v", err)
+    }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "os"
+    "time"
+)
+
+//------------------------------------------------------------
+// Marker file for find -newer and make
+//------------------------------------------------------------
+
+// Creates marker file if missing and gives it time t, so files newer
+// than the plan compare newer than the marker. Content of an existing
+// marker is kept.
+func writeMarker(fpath string, t time.Time) error {
+    f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+    if err = f.Close(); err != nil {
+        return err
+    }
+    return os.Chtimes(fpath, t, t)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// Marker takes the newest time of the plan, whether the plan is applied
+// or only dry run, and an existing marker keeps its content.
+func TestWriteMarker(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1"})
+    r.commit("2021-06-01T12:00:00Z", map[string]string{"b": "2"})
+    r.commit("2020-06-01T00:00:00Z", map[string]string{"c": "1"})
+    newest := mustTime(t, "2021-06-01T12:00:00Z")
+
+    tests := []struct {
+        name string
+        args []string
+    }{
+        {"apply", nil},
+        {"dry run", []string{"--dry-run"}},
+        {"existing", []string{"--dry-run"}},
+    }
+    marker := filepath.Join(t.TempDir(), "marker")
+    for _, tt := range tests {
+        if tt.name == "existing" {
+            if err := os.WriteFile(marker, []byte("kept"), 0644); err != nil {
+                t.Fatal(err)
+            }
+        } else {
+            os.Remove(marker)
+        }
+
+        run := r.run(append(tt.args, "--write-marker", marker)...)
+        if run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.name, run.Code, run.Stderr)
+        }
+        fi, err := os.Stat(marker)
+        if err != nil {
+            t.Fatalf("%v: %v", tt.name, err)
+        }
+        if !fi.ModTime().Equal(newest) {
+            t.Errorf("%v: marker at %v, want %v", tt.name, fi.ModTime(), newest)
+        }
+        if tt.name == "existing" {
+            if data, _ := os.ReadFile(marker); string(data) != "kept" {
+                t.Errorf("%v: content %q not kept", tt.name, data)
+            }
+        }
+    }
+}
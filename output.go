@@ -0,0 +1,186 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+    "text/template"
+    "time"
+)
+
+//------------------------------------------------------------
+// Printing plan entries
+//------------------------------------------------------------
+
+// Where records go, standard output. It is line buffered: each record
+// is built whole and written with a single call as soon as its file is
+// handled, so consumers reading a pipe see records in real time and
+// never get half of one.
+var recordOut io.Writer = os.Stdout
+
+// Writes one record, formatted as fmt.Printf does.
+func printRecord(format string, args ...interface{}) {
+    io.WriteString(recordOut, fmt.Sprintf(format, args...))
+}
+
+// Prints time and path of a file, and its commit if asked.
+// NUL separated records are "<RFC3339-time>\0[<commit>\0]<path>\0".
+// JSON records are one object per line. Text lines flag files given
+// a sentinel time with "(unresolved)" before the colon.
+func printEntry(e planEntry, opts Options) {
+    if !opts.ShowCommit {
+        e.Commit = ""
+    }
+
+    switch {
+    case opts.Format == "template":
+        printOutcome(e, opts, false, nil)
+    case opts.EmitScript:
+        printRecord("touch%v -d %v -- %v\n", touchFlags(opts), e.Mtime.UTC().Format("2006-01-02T15:04:05.999999999Z"), shellQuote(e.Path))
+    case opts.Format == "json":
+        data, _ := json.Marshal(e)
+        printRecord("%s\n", data)
+    case opts.Print0 && opts.ShowCommit:
+        printRecord("%s\x00%s\x00%s\x00", e.Mtime.Format(time.RFC3339), e.Commit, e.Path)
+    case opts.Print0:
+        printRecord("%s\x00%s\x00", e.Mtime.Format(time.RFC3339), e.Path)
+    default:
+        fields := []interface{}{formatTime(e.Mtime, opts.TimeFormat)}
+        if opts.ShowCommit {
+            fields = append(fields, shortHash(e.Commit))
+        }
+        if e.Unresolved {
+            fields = append(fields, "(unresolved)")
+        }
+        printRecord("%s", fmt.Sprintln(append(fields, ":", e.Path)...))
+    }
+}
+
+// Entry as seen by --template.
+type templateEntry struct {
+    Path       string
+    Mtime      time.Time
+    Commit     string
+    Unresolved bool
+    Applied    bool   // Time was changed
+    Error      string // Why changing time failed, if it did
+}
+
+// Template of --template, parsed once options are valid.
+var entryTemplate *template.Template
+
+// Parses template of entries, trying it on a sample entry so unknown
+// fields are caught before any file is retimed.
+func parseEntryTemplate(text string) (tmpl *template.Template, err error) {
+    tmpl, err = template.New("entry").Parse(text)
+    if err != nil {
+        return
+    }
+    err = tmpl.Execute(io.Discard, templateEntry{Mtime: time.Now()})
+    return
+}
+
+// Prints entry through template with outcome of changing its time.
+// Other formats are printed before the change, see printEntry.
+func printOutcome(e planEntry, opts Options, applied bool, err error) {
+    if opts.Format != "template" {
+        return
+    }
+    if !opts.ShowCommit {
+        e.Commit = ""
+    }
+
+    te := templateEntry{Path: e.Path, Mtime: e.Mtime, Commit: e.Commit, Unresolved: e.Unresolved, Applied: applied}
+    if err != nil {
+        te.Error = err.Error()
+    }
+    var buf strings.Builder
+    if err := entryTemplate.Execute(&buf, te); err != nil {
+        fmt.Fprintf(os.Stderr, "Error rendering template: %v\n", err)
+        return
+    }
+    printRecord("%s\n", buf.String())
+}
+
+// Flags of touch in script doing what options tell, -m keeping access
+// time and -h retiming symlinks themselves.
+func touchFlags(opts Options) (flags string) {
+    if opts.KeepAtime {
+        flags += " -m"
+    }
+    if opts.NoDereference {
+        flags += " -h"
+    }
+    return
+}
+
+// Prints start of script setting file times, see --emit-script.
+// Paths in it are relative to root.
+func printScriptHeader(root string) {
+    fmt.Println("#!/bin/sh")
+    fmt.Println("# Generated by gitime, sets times of files under the directory")
+    fmt.Println("set -e")
+    fmt.Printf("cd -- %v\n", shellQuote(root))
+}
+
+// Quotes string for POSIX shell, in single quotes which keep all but
+// a single quote as is.
+func shellQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Prints path of file alone, as --print-files does.
+func printPath(f string, opts Options) {
+    switch {
+    case opts.Format == "json":
+        data, _ := json.Marshal(struct {
+            Path string `json:"path"`
+        }{f})
+        printRecord("%s\n", data)
+    case opts.Print0:
+        printRecord("%s\x00", f)
+    default:
+        printRecord("%s\n", f)
+    }
+}
+
+// Prints commit of --print-commits, one JSON object per line with json
+// format.
+func printCommit(c planCommit, opts Options) {
+    if opts.Format == "json" {
+        data, _ := json.Marshal(c)
+        printRecord("%s\n", data)
+        return
+    }
+    printRecord("%v %v : %d files\n", formatTime(c.Mtime, opts.TimeFormat), shortHash(c.Commit), c.Files)
+}
+
+// Formats time for text output as --time-format tells, a preset name
+// or a Go time layout.
+func formatTime(t time.Time, format string) string {
+    switch format {
+    case "rfc3339":
+        return t.Format(time.RFC3339)
+    case "unix":
+        return strconv.FormatInt(t.Unix(), 10)
+    case "date":
+        return t.Format("2006-01-02")
+    default:
+        return t.Format(format)
+    }
+}
+
+// Abbreviates commit hash for text output, "-" if no commit.
+func shortHash(hash string) string {
+    switch {
+    case hash == "":
+        return "-"
+    case len(hash) > 7:
+        return hash[:7]
+    default:
+        return hash
+    }
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "os"
+    "os/exec"
+    "strings"
+    "testing"
+    "time"
+)
+
+// NUL separated records keep paths with any characters whole, as time
+// and path alternate.
+func TestPrint0(t *testing.T) {
+    r := newTestRepo(t)
+    tricky := "new\nline and space\ttab"
+    r.commit("2020-01-01T00:00:00Z", map[string]string{tricky: "1", "plain": "1"})
+
+    run := r.run("--dry-run", "--print0")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !strings.HasSuffix(run.Stdout, "\x00") {
+        t.Fatalf("record not NUL terminated: %q", run.Stdout)
+    }
+    fields := strings.Split(strings.TrimSuffix(run.Stdout, "\x00"), "\x00")
+    if len(fields) != 4 {
+        t.Fatalf("got %d fields, want time and path of 2 files: %q", len(fields), run.Stdout)
+    }
+    paths := map[string]bool{}
+    for i := 0; i < len(fields); i += 2 {
+        if _, err := time.Parse(time.RFC3339, fields[i]); err != nil {
+            t.Errorf("bad time %q: %v", fields[i], err)
+        }
+        paths[fields[i+1]] = true
+    }
+    if !paths[tricky] || !paths["plain"] {
+        t.Errorf("got paths %v", paths)
+    }
+}
+
+// Script quotes tricky paths for the shell and, run in any directory,
+// sets the times of the plan.
+func TestEmitScript(t *testing.T) {
+    r := newTestRepo(t)
+    tricky := "it's $HOME `x` & \"y\".txt"
+    r.commit("2020-01-01T00:00:00Z", map[string]string{tricky: "1", "plain": "1"})
+    now := time.Now().Truncate(time.Second)
+    r.setMtime(tricky, now)
+
+    run := r.run("--emit-script")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !strings.Contains(run.Stdout, ` -- 'it'\''s $HOME `+"`x`"+` & "y".txt'`+"\n") {
+        t.Errorf("path not quoted: %v", run.Stdout)
+    }
+    if !r.mtime(tricky).Equal(now) {
+        t.Errorf("emitting a script changed a time")
+    }
+
+    sh := exec.Command("sh", "-c", run.Stdout)
+    sh.Dir = t.TempDir()
+    if out, err := sh.CombinedOutput(); err != nil {
+        t.Fatalf("script failed: %v\n%s", err, out)
+    }
+    if got := r.mtime(tricky); !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("script gave %v", got)
+    }
+}
+
+// Each entry is rendered through the template with the outcome of
+// changing its time, a bad template fails before any file is retimed.
+func TestTemplateFormat(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("a", now)
+
+    run := r.run("--format", "template", "--template", `{{.Mtime.UTC.Format "2006"}} {{.Path}} applied={{.Applied}} error={{.Error}}`)
+    if run.Code != 0 || run.Stdout != "2020 a applied=true error=\n" {
+        t.Errorf("exit status %d: %q %v", run.Code, run.Stdout, run.Stderr)
+    }
+
+    r.setMtime("a", now)
+    for _, tmpl := range []string{"{{.Path", "{{.NoSuchField}}"} {
+        run := r.run("--format", "template", "--template", tmpl)
+        if run.Code != 2 || !strings.Contains(run.Stderr, "Bad --template: ") {
+            t.Errorf("%q: exit status %d: %v", tmpl, run.Code, run.Stderr)
+        }
+    }
+    if !r.mtime("a").Equal(now) {
+        t.Errorf("bad template retimed a")
+    }
+}
+
+// Times of text output follow --time-format, a preset or a Go layout.
+func TestTimeFormat(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-02T03:04:05Z", map[string]string{"a": "1"})
+
+    tests := []struct {
+        format string
+        want   string
+    }{
+        {"rfc3339", "2020-01-02T03:04:05Z : a\n"},
+        {"unix", "1577934245 : a\n"},
+        {"date", "2020-01-02 : a\n"},
+        {"Jan 2 2006 15:04", "Jan 2 2020 03:04 : a\n"},
+    }
+    for _, tt := range tests {
+        run := r.run("--dry-run", "--time-format", tt.format)
+        if run.Code != 0 || run.Stdout != tt.want {
+            t.Errorf("%v: exit status %d: got %q, want %q", tt.format, run.Code, run.Stdout, tt.want)
+        }
+    }
+    if run := r.run("--dry-run"); run.Stdout != tests[0].want {
+        t.Errorf("default: got %q, want RFC3339", run.Stdout)
+    }
+}
+
+// Records reach a pipe as each file is handled, not when gitime exits,
+// each one whole.
+func TestRecordsStreamToPipe(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1"})
+
+    cmd := exec.Command(os.Args[0], "--format", "json", "--chunk-size", "1", "--chunk-pause", "300ms")
+    cmd.Dir = r.Dir
+    cmd.Env = append(os.Environ(), "GITIME_TEST_MAIN=1")
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := cmd.Start(); err != nil {
+        t.Fatal(err)
+    }
+
+    var arrived []time.Time
+    lines := bufio.NewScanner(stdout)
+    for lines.Scan() {
+        arrived = append(arrived, time.Now())
+        var e planEntry
+        if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+            t.Errorf("record %q not whole: %v", lines.Text(), err)
+        }
+    }
+    done := time.Now()
+    if err := cmd.Wait(); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(arrived) != 3 {
+        t.Fatalf("got %d records, want 3", len(arrived))
+    }
+    // Two pauses follow the first record
+    if wait := done.Sub(arrived[0]); wait < 500*time.Millisecond {
+        t.Errorf("first record came %v before exit, want it before the pauses", wait)
+    }
+}
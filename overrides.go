@@ -0,0 +1,156 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//------------------------------------------------------------
+// Explicit times for files where git history is wrong
+//------------------------------------------------------------
+
+// Time override for files matching a pattern.
+type override struct {
+    Pattern string
+    Mtime   time.Time
+}
+
+// Longest override file line read, paths may be long but not this long.
+const maxOverrideLine = 1 << 20
+
+// Reads override file, one "<path-or-glob> <RFC3339-time>" per line.
+// Blank lines and lines starting with # are ignored. Time is the last
+// field, so patterns may contain spaces.
+func loadOverrides(fpath string) (overrides []override, err error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), maxOverrideLine)
+    n := 1
+    for ; scanner.Scan(); n++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        idx := strings.LastIndexAny(line, " \t")
+        if idx == -1 {
+            return nil, fmt.Errorf("%v:%d: expected path and time", fpath, n)
+        }
+        pattern, raw := strings.TrimSpace(line[:idx]), line[idx+1:]
+
+        if _, err = path.Match(pattern, ""); err != nil {
+            return nil, fmt.Errorf("%v:%d: bad pattern %q", fpath, n, pattern)
+        }
+        mtime, err := time.Parse(time.RFC3339, raw)
+        if err != nil {
+            return nil, fmt.Errorf("%v:%d: bad RFC3339 time %q", fpath, n, raw)
+        }
+        overrides = append(overrides, override{Pattern: pattern, Mtime: mtime})
+    }
+    err = scanner.Err()
+    if errors.Is(err, bufio.ErrTooLong) {
+        err = fmt.Errorf("%v:%d: line longer than %d bytes", fpath, n, maxOverrideLine)
+    }
+    return
+}
+
+// Replaces computed times of files matching an override.
+// Overrides win over history, later lines win over earlier ones.
+func applyOverrides(plan []planEntry, overrides []override) (count int) {
+    for i := range plan {
+        matched := false
+        for _, o := range overrides {
+            if ok, _ := path.Match(o.Pattern, plan[i].Path); ok {
+                plan[i].Mtime = o.Mtime
+                plan[i].Commit = ""
+                matched = true
+            }
+        }
+        if matched {
+            count++
+        }
+    }
+    return
+}
+
+// Lines of a file whose last change gives the file its time.
+type lineRange struct {
+    File       string
+    Start, End int
+}
+
+// Parses line range like "<file>:<start>-<end>", lines counting from 1.
+func parseLineRange(s string) (lr lineRange, err error) {
+    idx := strings.LastIndexByte(s, ':')
+    if idx <= 0 {
+        return lr, fmt.Errorf("expected <file>:<start>-<end>: %v", s)
+    }
+    lr.File = s[:idx]
+
+    from, to, ok := strings.Cut(s[idx+1:], "-")
+    if !ok {
+        return lr, fmt.Errorf("expected <file>:<start>-<end>: %v", s)
+    }
+    if lr.Start, err = strconv.Atoi(from); err == nil {
+        lr.End, err = strconv.Atoi(to)
+    }
+    if err != nil || lr.Start < 1 || lr.End < lr.Start {
+        return lr, fmt.Errorf("bad line range: %v", s[idx+1:])
+    }
+    return lr, nil
+}
+
+// Replaces times of files with that of the last change to their line
+// range. Runs git log -L for each range, which diffs every commit
+// touching the file, so is meant for a few files only.
+func applyLineRanges(plan []planEntry, ranges []lineRange) (count int, err error) {
+    idx := map[string]int{}
+    for i, e := range plan {
+        idx[e.Path] = i
+    }
+
+    for _, lr := range ranges {
+        i, ok := idx[lr.File]
+        if !ok {
+            fmt.Fprintf(os.Stderr, "WARNING line range of file not retimed: %v\n", lr.File)
+            continue
+        }
+        hash, mtime, err := gitLineRangeCommit(lr.File, lr.Start, lr.End)
+        if err != nil {
+            return count, fmt.Errorf("%v:%d-%d: %v", lr.File, lr.Start, lr.End, err)
+        }
+        plan[i].Mtime = mtime
+        plan[i].Commit = hash
+        count++
+    }
+    return
+}
+
+// Gives files of the plan the time of the newest commit among their
+// lines at HEAD, newest surviving content. It differs from the last
+// commit of a file if that one only deleted lines. Files git can't
+// blame keep their time.
+func applyBlame(plan []planEntry) (count, kept int) {
+    for i, e := range plan {
+        hash, mtime, err := gitBlameNewest(e.Path)
+        if err != nil || hash == "" {
+            kept++
+            continue
+        }
+        plan[i].Mtime = mtime
+        plan[i].Commit = hash
+        count++
+    }
+    return
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Overrides replace times of history for files matching them, a later
+// line winning over an earlier one. Bad lines refuse the file.
+func TestOverrideFile(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a.go": "1", "b.go": "1", "c.txt": "1"})
+    dir := t.TempDir()
+    overrides := filepath.Join(dir, "overrides")
+    text := "# bogus import dates\n\n*.go 2019-01-01T00:00:00Z\nb.go 2018-06-01T12:00:00+02:00\n"
+    if err := os.WriteFile(overrides, []byte(text), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    run := r.run("--override-file", overrides)
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Overridden times of 2 files") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for f, want := range map[string]string{"a.go": "2019-01-01T00:00:00Z", "b.go": "2018-06-01T10:00:00Z", "c.txt": "2020-01-01T00:00:00Z"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, got, want)
+        }
+    }
+
+    for _, bad := range []string{"a.go 2019-01-01\n", "a.go\n", "[ 2019-01-01T00:00:00Z\n"} {
+        fpath := filepath.Join(dir, "bad")
+        if err := os.WriteFile(fpath, []byte(bad), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if run := r.run("--override-file", fpath); run.Code != 1 || !strings.Contains(run.Stderr, "Error reading override file: "+fpath+":1:") {
+            t.Errorf("%q: exit status %d: %v", bad, run.Code, run.Stderr)
+        }
+    }
+}
+
+// Lines of override files may be long, one too long for the scanner
+// fails with its file and line.
+func TestOverrideLongLine(t *testing.T) {
+    fpath := filepath.Join(t.TempDir(), "overrides")
+    long := strings.Repeat("x", 200000)
+    text := long + " 2020-01-01T00:00:00Z\n" + strings.Repeat("y", maxOverrideLine) + " 2020-01-01T00:00:00Z\n"
+    if err := os.WriteFile(fpath, []byte(text), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    _, err := loadOverrides(fpath)
+    if want := fmt.Sprintf("%v:2: line longer than %d bytes", fpath, maxOverrideLine); err == nil || err.Error() != want {
+        t.Errorf("got %v, want %v", err, want)
+    }
+    if err := os.WriteFile(fpath, []byte(long+" 2020-01-01T00:00:00Z\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if overrides, err := loadOverrides(fpath); err != nil || len(overrides) != 1 || overrides[0].Pattern != long {
+        t.Errorf("got %d overrides: %v", len(overrides), err)
+    }
+}
+
+// A line range gives its file the time of the last change to those
+// lines, a later change elsewhere in the file doesn't count.
+func TestLineRange(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"f.go": "// License v1\nfunc body() {}\n", "g.go": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"f.go": "// License v2\nfunc body() {}\n", "g.go": "2"})
+
+    if run := r.run("--line-range", "f.go:2-2"); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for f, want := range map[string]string{"f.go": "2020-01-01T00:00:00Z", "g.go": "2021-01-01T00:00:00Z"} {
+        if got := r.mtime(f); !got.Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, got, want)
+        }
+    }
+    if run := r.run("--line-range", "f.go:2"); run.Code != 2 {
+        t.Errorf("bad range: exit status %d: %v", run.Code, run.Stderr)
+    }
+}
+
+// Last wins lets rounding and granularity adjust an override like any
+// time of history, overrides win keeps it exact.
+func TestOnConflict(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"src/a": "1", "src/b": "1"})
+    r.commit("2020-06-01T00:00:00Z", map[string]string{"src/b": "2"})
+    overrides := filepath.Join(t.TempDir(), "overrides")
+    if err := os.WriteFile(overrides, []byte("src/a 2021-03-05T10:20:37Z\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        args []string
+        want map[string]string
+    }{
+        {[]string{"--round", "1h"}, map[string]string{"src/a": "2021-03-05T10:00:00Z", "src/b": "2020-06-01T00:00:00Z"}},
+        {[]string{"--round", "1h", "--on-conflict", "overrides-win"}, map[string]string{"src/a": "2021-03-05T10:20:37Z", "src/b": "2020-06-01T00:00:00Z"}},
+        {[]string{"--granularity", "component"}, map[string]string{"src/a": "2021-03-05T10:20:37Z", "src/b": "2021-03-05T10:20:37Z"}},
+        {[]string{"--granularity", "component", "--on-conflict", "overrides-win"}, map[string]string{"src/a": "2021-03-05T10:20:37Z", "src/b": "2020-06-01T00:00:00Z"}},
+    }
+    for _, tt := range tests {
+        run := r.run(append([]string{"--dry-run", "--override-file", overrides}, tt.args...)...)
+        if run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.args, run.Code, run.Stderr)
+        }
+        got := run.times(t)
+        for f, date := range tt.want {
+            if !got[f].Equal(mustTime(t, date)) {
+                t.Errorf("%v: %v got %v, want %v", tt.args, f, got[f], date)
+            }
+        }
+    }
+}
+
+// A commit only deleting lines leaves the file the time of its newest
+// surviving line by blame, while it's the last commit touching it.
+func TestByBlame(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"f": "one\ntwo\n", "g": "one\n"})
+    r.commit("2020-06-01T00:00:00Z", map[string]string{"f": "one\ntwo\nthree\n", "g": "one\ntwo\n"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"f": "one\ntwo\n", "g": "one\ntwo\nthree\n"})
+
+    tests := []struct {
+        args []string
+        want map[string]string
+    }{
+        {nil, map[string]string{"f": "2021-01-01T00:00:00Z", "g": "2021-01-01T00:00:00Z"}},
+        {[]string{"--by-blame"}, map[string]string{"f": "2020-01-01T00:00:00Z", "g": "2021-01-01T00:00:00Z"}},
+    }
+    for _, tt := range tests {
+        run := r.run(append([]string{"--dry-run"}, tt.args...)...)
+        if run.Code != 0 {
+            t.Fatalf("%v: exit status %d: %v", tt.args, run.Code, run.Stderr)
+        }
+        got := run.times(t)
+        for f, date := range tt.want {
+            if !got[f].Equal(mustTime(t, date)) {
+                t.Errorf("%v: %v got %v, want %v", tt.args, f, got[f], date)
+            }
+        }
+    }
+}
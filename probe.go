@@ -0,0 +1,138 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "time"
+)
+
+//------------------------------------------------------------
+// Environment diagnostics for reports of problems
+//------------------------------------------------------------
+
+// Time written to the probe file, odd seconds and all digits of
+// nanoseconds set so any truncation shows.
+var probeTime = time.Unix(1700000001, 123456789)
+
+// Resolutions file systems store times in, finest first.
+var probeResolutions = []time.Duration{
+    time.Nanosecond, time.Microsecond, time.Millisecond, 10 * time.Millisecond,
+    100 * time.Millisecond, time.Second, 2 * time.Second,
+}
+
+// Prints what is known of git, the repository in current directory and
+// its file system, one "name: value" per line. Each probe failing is
+// reported in its line, the rest still run.
+func probe() {
+    fmt.Printf("platform: %v/%v %v\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+    gitPath, err := exec.LookPath(gitExecutable)
+    if err != nil {
+        fmt.Printf("git: error: %v\n", err)
+        return
+    }
+    version, err := gitVersion()
+    fmt.Printf("git: %v %v\n", gitPath, probeValue(version, err))
+
+    inside, err := probeGit("rev-parse", "--is-inside-work-tree")
+    if err != nil {
+        fmt.Printf("repository: none: %v\n", err)
+        return
+    }
+    gitDir, _ := gitAbsoluteDir()
+    // Relative to current directory, --path-format needs git 2.31
+    commonDir, _ := probeGit("rev-parse", "--git-common-dir")
+    if commonDir != "" {
+        commonDir, _ = filepath.Abs(commonDir)
+    }
+    super, _ := probeGit("rev-parse", "--show-superproject-working-tree")
+    bare, _ := probeGit("rev-parse", "--is-bare-repository")
+    shallow, err := gitIsShallow()
+    fmt.Printf("git dir: %v\n", gitDir)
+    fmt.Printf("work tree: %v\n", inside == "true")
+    fmt.Printf("bare: %v\n", bare == "true")
+    fmt.Printf("linked worktree: %v\n", commonDir != "" && filepath.Clean(commonDir) != filepath.Clean(gitDir))
+    fmt.Printf("submodule: %v\n", super != "")
+    fmt.Printf("shallow: %v\n", probeValue(shallow, err))
+
+    ref, err := probeGit("symbolic-ref", "-q", "HEAD")
+    if err != nil {
+        ref = "(detached)"
+    }
+    hash, err := gitResolveCommit("HEAD")
+    unborn := err != nil
+    if unborn {
+        hash = "(no commits yet)"
+    }
+    fmt.Printf("HEAD: %v %v\n", ref, hash)
+    count, err := "0", error(nil)
+    if !unborn {
+        count, err = probeGit("rev-list", "--count", "HEAD")
+    }
+    fmt.Printf("commits: %v\n", probeValue(count, err))
+
+    if inside != "true" {
+        return
+    }
+    workTree, err := gitTopLevel()
+    if err != nil {
+        fmt.Printf("work tree top: error: %v\n", err)
+        return
+    }
+    gitWorkTree = workTree
+    fmt.Printf("work tree top: %v\n", workTree)
+    var modes map[string]string
+    if !unborn {
+        modes, err = gitTreeModes()
+    }
+    fmt.Printf("tracked files: %v\n", probeValue(len(modes), err))
+    res, err := probeResolution(workTree)
+    fmt.Printf("mtime resolution: %v\n", probeValue(res, err))
+    folds, err := probeCaseFolding(workTree)
+    fmt.Printf("case folding: %v\n", probeValue(folds, err))
+}
+
+// Runs git returning its output trimmed.
+func probeGit(args ...string) (out string, err error) {
+    data, err := runGit(args...)
+    out = strings.TrimSpace(string(data))
+    return
+}
+
+// Formats value of a probe, or its error.
+func probeValue(v interface{}, err error) string {
+    if err != nil {
+        return "error: " + err.Error()
+    }
+    return fmt.Sprint(v)
+}
+
+// Finds resolution of file times in directory by setting the time of
+// a temporary file and reading it back.
+func probeResolution(dir string) (res time.Duration, err error) {
+    f, err := os.CreateTemp(dir, ".gitime-probe-*")
+    if err != nil {
+        return
+    }
+    fpath := f.Name()
+    f.Close()
+    defer os.Remove(fpath)
+
+    if err = os.Chtimes(fpath, probeTime, probeTime); err != nil {
+        return
+    }
+    fi, err := os.Stat(fpath)
+    if err != nil {
+        return
+    }
+    for _, res = range probeResolutions {
+        if probeTime.Truncate(res).Equal(fi.ModTime()) {
+            return
+        }
+    }
+    return 0, fmt.Errorf("mtime %v read back as %v", probeTime.Format(time.RFC3339Nano), fi.ModTime().Format(time.RFC3339Nano))
+}
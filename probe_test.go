@@ -0,0 +1,50 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// Probe prints git, repository layout, HEAD, counts and file system
+// resolution, and says so outside of a repository.
+func TestProbe(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    r.commit("2021-01-01T00:00:00Z", map[string]string{"b": "1"})
+    head := strings.TrimSpace(r.git("rev-parse", "HEAD"))
+    branch := strings.TrimSpace(r.git("symbolic-ref", "HEAD"))
+
+    run := r.run("--probe")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    fields := map[string]string{}
+    for _, line := range strings.Split(strings.TrimSuffix(run.Stdout, "\n"), "\n") {
+        name, value, _ := strings.Cut(line, ": ")
+        fields[name] = value
+    }
+    want := map[string]string{
+        "work tree":       "true",
+        "bare":            "false",
+        "linked worktree": "false",
+        "shallow":         "false",
+        "HEAD":            branch + " " + head,
+        "commits":         "2",
+        "tracked files":   "2",
+    }
+    for name, value := range want {
+        if fields[name] != value {
+            t.Errorf("%v: got %q, want %q", name, fields[name], value)
+        }
+    }
+    for _, name := range []string{"platform", "git", "git dir", "mtime resolution"} {
+        if v := fields[name]; v == "" || strings.HasPrefix(v, "error") {
+            t.Errorf("%v: got %q", name, v)
+        }
+    }
+
+    run = runGitime(t, t.TempDir(), "", "--probe")
+    if run.Code != 0 || !strings.Contains(run.Stdout, "repository: none") {
+        t.Errorf("outside of repository: exit status %d: %q", run.Code, run.Stdout)
+    }
+}
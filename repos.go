@@ -0,0 +1,147 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "sync"
+)
+
+//------------------------------------------------------------
+// Retiming several repositories in one run
+//------------------------------------------------------------
+
+// Outcome of retiming one repository.
+type repoResult struct {
+    Repo     string
+    ExitCode int // -1 if not run, as an earlier one failed
+    Stdout   []byte
+    Stderr   []byte
+}
+
+// Runs gitime in each repository as its own process with the same
+// options, up to jobs at once. Repositories are independent, each takes
+// its own lock and exits on its own errors. Relative paths in options
+// are taken from each repository, like with cd <repo> && gitime.
+// Output of each is printed in one piece under a header when run in
+// parallel, in order of repositories, whichever finishes first, errors
+// to standard error under a header of their own. It is
+// streamed when one at a time, as jobs of 0 or 1 do. With fail fast no
+// more runs start once one fails.
+// Returns highest exit code of all.
+func runRepos(repos []string, args []string, jobs int, failFast bool) (code int) {
+    if jobs < 1 {
+        jobs = 1
+    }
+    self, err := os.Executable()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error finding gitime executable: %v\n", err)
+        return 1
+    }
+
+    results := make([]repoResult, len(repos))
+    finished := make([]bool, len(repos))
+    next := 0 // First repository not printed yet
+    var mu sync.Mutex
+    failed := false
+
+    // Called with mu held, prints what can be printed in order
+    printDone := func() {
+        for next < len(repos) && finished[next] {
+            if res := results[next]; res.ExitCode != -1 {
+                fmt.Printf("== %v\n", res.Repo)
+                os.Stdout.Write(res.Stdout)
+                if len(res.Stderr) > 0 {
+                    fmt.Fprintf(os.Stderr, "== %v\n", res.Repo)
+                    os.Stderr.Write(res.Stderr)
+                }
+            }
+            next++
+        }
+    }
+
+    idx := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < jobs; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range idx {
+                mu.Lock()
+                skip := failFast && failed
+                if skip {
+                    results[i] = repoResult{Repo: repos[i], ExitCode: -1}
+                    finished[i] = true
+                    if jobs > 1 {
+                        printDone()
+                    }
+                }
+                mu.Unlock()
+                if skip {
+                    continue
+                }
+
+                res := runRepo(self, repos[i], args, jobs == 1)
+                mu.Lock()
+                results[i] = res
+                finished[i] = true
+                failed = failed || res.ExitCode != 0
+                if jobs > 1 {
+                    printDone()
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+    for i := range repos {
+        idx <- i
+    }
+    close(idx)
+    wg.Wait()
+
+    fmt.Fprintln(os.Stderr, "Repositories:")
+    for _, res := range results {
+        switch res.ExitCode {
+        case -1:
+            fmt.Fprintf(os.Stderr, "  %v: not run\n", res.Repo)
+        case 0:
+            fmt.Fprintf(os.Stderr, "  %v: ok\n", res.Repo)
+        default:
+            fmt.Fprintf(os.Stderr, "  %v: failed, exit status %d\n", res.Repo, res.ExitCode)
+        }
+        code = max(code, res.ExitCode)
+    }
+    return
+}
+
+// Runs gitime in one repository, streaming its output or keeping it.
+func runRepo(self, repo string, args []string, stream bool) (res repoResult) {
+    res.Repo = repo
+    cmd := exec.Command(self, args...)
+    cmd.Dir = repo
+
+    var stdout, stderr bytes.Buffer
+    if stream {
+        fmt.Printf("== %v\n", repo)
+        cmd.Stdout = os.Stdout
+        cmd.Stderr = os.Stderr
+    } else {
+        cmd.Stdout = &stdout
+        cmd.Stderr = &stderr
+    }
+
+    err := cmd.Run()
+    res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+    var exitErr *exec.ExitError
+    switch {
+    case errors.As(err, &exitErr):
+        res.ExitCode = exitErr.ExitCode()
+    case err != nil:
+        // Not a directory or such, git would have said no repository
+        fmt.Fprintf(os.Stderr, "Error running gitime in %v: %v\n", repo, err)
+        res.ExitCode = 1
+    }
+    return
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// Each of several repositories is retimed on its own, a failing one
+// doesn't stop the others and sets the exit status.
+func TestRunRepos(t *testing.T) {
+    first, second := newTestRepo(t), newTestRepo(t)
+    first.commit("2020-01-01T00:00:00Z", map[string]string{"one": "1"})
+    second.commit("2021-01-01T00:00:00Z", map[string]string{"two": "2"})
+    notRepo := t.TempDir()
+
+    run := runGitime(t, t.TempDir(), "", first.Dir, notRepo, second.Dir)
+    if run.Code == 0 {
+        t.Errorf("exit status 0 with a failed repository")
+    }
+    if !first.mtime("one").Equal(mustTime(t, "2020-01-01T00:00:00Z")) || !second.mtime("two").Equal(mustTime(t, "2021-01-01T00:00:00Z")) {
+        t.Errorf("repositories not retimed: %v", run.Stdout)
+    }
+    for _, want := range []string{first.Dir + ": ok", notRepo + ": failed", second.Dir + ": ok"} {
+        if !strings.Contains(run.Stderr, want) {
+            t.Errorf("summary lacks %q: %v", want, run.Stderr)
+        }
+    }
+}
+
+// Output comes under one header per repository in order of arguments,
+// whether run one at a time or several at once.
+func TestRunReposOrder(t *testing.T) {
+    var dirs []string
+    for i := 0; i < 4; i++ {
+        r := newTestRepo(t)
+        r.commit("2020-01-01T00:00:00Z", map[string]string{"f": "1"})
+        dirs = append(dirs, r.Dir)
+    }
+
+    for _, jobs := range []string{"1", "3"} {
+        run := runGitime(t, t.TempDir(), "", append([]string{"--repo-jobs", jobs}, dirs...)...)
+        if run.Code != 0 {
+            t.Fatalf("jobs %v: exit status %d: %v", jobs, run.Code, run.Stderr)
+        }
+        var headers []string
+        for _, line := range strings.Split(run.Stdout, "\n") {
+            if strings.HasPrefix(line, "== ") {
+                headers = append(headers, strings.TrimPrefix(line, "== "))
+            }
+        }
+        if strings.Join(headers, " ") != strings.Join(dirs, " ") {
+            t.Errorf("jobs %v: got headers %v, want %v", jobs, headers, dirs)
+        }
+    }
+}
+
+// With fail fast repositories after a failed one aren't run, nor is
+// any header printed twice.
+func TestRunReposFailFast(t *testing.T) {
+    notRepo := t.TempDir()
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"f": "1"})
+
+    for _, jobs := range []string{"1", "2"} {
+        run := runGitime(t, t.TempDir(), "", "--repo-jobs", jobs, "--fail-fast", notRepo, r.Dir)
+        if run.Code == 0 {
+            t.Errorf("jobs %v: exit status 0 with a failed repository", jobs)
+        }
+        if n := strings.Count(run.Stdout, "== "+notRepo+"\n"); n != 1 {
+            t.Errorf("jobs %v: header of failed repository printed %d times: %v", jobs, n, run.Stdout)
+        }
+        if jobs == "1" && !strings.Contains(run.Stderr, r.Dir+": not run") {
+            t.Errorf("jobs %v: repository after failed one was run: %v", jobs, run.Stderr)
+        }
+    }
+}
+
+// Run in parallel, errors of each repository go to standard error under
+// its header, output to standard output.
+func TestRunReposErrorsToStderr(t *testing.T) {
+    notRepo := t.TempDir()
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"f": "1"})
+
+    run := runGitime(t, t.TempDir(), "", "--repo-jobs", "2", notRepo, r.Dir)
+    if run.Code == 0 {
+        t.Errorf("exit status 0 with a failed repository")
+    }
+    if strings.Contains(run.Stdout, "not a git repository") || !strings.Contains(run.Stdout, ": f") {
+        t.Errorf("stdout has errors or lacks output: %v", run.Stdout)
+    }
+    if _, errs, _ := strings.Cut(run.Stderr, "== "+notRepo+"\n"); !strings.Contains(errs, "not a git repository") {
+        t.Errorf("stderr lacks errors under header of failed repository: %v", run.Stderr)
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Resuming an interrupted run
+//------------------------------------------------------------
+
+// Resume file starts with this and the HEAD it was written for,
+// followed by NUL terminated paths of files applied.
+const resumeHeader = "gitime-resume "
+
+// Resume file of this run, nil if none or writing it failed.
+var resumeLog *os.File
+
+// Opens resume file for HEAD, returning files an earlier run at the
+// same HEAD applied. A file of another HEAD is started over. The file is
+// rewritten with the files read, dropping a record cut by interruption.
+func openResume(fpath, head string) (done map[string]bool, err error) {
+    data, err := os.ReadFile(fpath)
+    if err != nil && !os.IsNotExist(err) {
+        return
+    }
+
+    header := resumeHeader + head + "\n"
+    done = map[string]bool{}
+    switch {
+    case strings.HasPrefix(string(data), header):
+        records := strings.Split(string(data[len(header):]), "\x00")
+        // The last record is empty unless cut short
+        for _, f := range records[:len(records)-1] {
+            done[f] = true
+        }
+    case len(data) > 0:
+        fmt.Fprintf(os.Stderr, "WARNING resume file is of another HEAD, starting over: %v\n", fpath)
+    }
+
+    f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return nil, err
+    }
+    var buf strings.Builder
+    buf.WriteString(header)
+    for path := range done {
+        buf.WriteString(path + "\x00")
+    }
+    if _, err = f.WriteString(buf.String()); err != nil {
+        f.Close()
+        return nil, err
+    }
+    resumeLog = f
+    return
+}
+
+// Records file as applied. Each file is written at once so a killed run
+// loses none of them.
+func recordApplied(f string) {
+    if resumeLog == nil {
+        return
+    }
+    if _, err := resumeLog.WriteString(f + "\x00"); err != nil {
+        fmt.Fprintf(os.Stderr, "WARNING could not write resume file, no longer recording: %v\n", err)
+        resumeLog.Close()
+        resumeLog = nil
+    }
+}
+
+// Removes resume file once the run is complete.
+func finishResume(fpath string) {
+    if resumeLog != nil {
+        resumeLog.Close()
+        resumeLog = nil
+    }
+    if err := os.Remove(fpath); err != nil && !os.IsNotExist(err) {
+        fmt.Fprintf(os.Stderr, "WARNING could not remove resume file: %v\n", err)
+    }
+}
+
+// Drops files of the plan an earlier run applied.
+func filterResumed(plan []planEntry, done map[string]bool) (kept []planEntry, count int) {
+    for _, e := range plan {
+        if done[e.Path] {
+            count++
+            continue
+        }
+        kept = append(kept, e)
+    }
+    return
+}
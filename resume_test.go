@@ -0,0 +1,80 @@
+package main
+
+import (
+    "bufio"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "testing"
+    "time"
+)
+
+// A run stopped part way leaves the files it applied in the resume file,
+// run again at the same HEAD it skips them and removes the file. At
+// another HEAD the file is started over.
+func TestResumeFile(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1"})
+    resume := filepath.Join(t.TempDir(), "resume")
+
+    cmd := exec.Command(os.Args[0], "--resume-file", resume, "--chunk-size", "1", "--chunk-pause", "10s")
+    cmd.Dir = r.Dir
+    cmd.Env = append(os.Environ(), "GITIME_TEST_MAIN=1")
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := cmd.Start(); err != nil {
+        t.Fatal(err)
+    }
+    lines := bufio.NewScanner(stdout)
+    if !lines.Scan() {
+        t.Fatal("no file applied before the pause")
+    }
+    first := lines.Text()[strings.LastIndex(lines.Text(), " ")+1:]
+    cmd.Process.Signal(syscall.SIGTERM)
+    cmd.Wait()
+
+    data, err := os.ReadFile(resume)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if head := strings.TrimSpace(r.git("rev-parse", "HEAD")); string(data) != resumeHeader+head+"\n"+first+"\x00" {
+        t.Fatalf("resume file %q, want %v applied", data, first)
+    }
+
+    now := time.Now().Truncate(time.Second)
+    for _, f := range []string{"a", "b", "c"} {
+        r.setMtime(f, now)
+    }
+    run := r.run("--resume-file", resume)
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Applied by earlier run skipped: 1") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for _, f := range []string{"a", "b", "c"} {
+        got := r.mtime(f)
+        switch {
+        case f == first && !got.Equal(now):
+            t.Errorf("%v applied again", f)
+        case f != first && !got.Equal(mustTime(t, "2020-01-01T00:00:00Z")):
+            t.Errorf("%v got %v", f, got)
+        }
+    }
+    if _, err := os.Stat(resume); !os.IsNotExist(err) {
+        t.Errorf("resume file left after a complete run: %v", err)
+    }
+
+    if err := os.WriteFile(resume, []byte(resumeHeader+"0123456789\n"+first+"\x00"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    r.setMtime(first, now)
+    run = r.run("--resume-file", resume)
+    if run.Code != 0 || !strings.Contains(run.Stderr, "resume file is of another HEAD") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if r.mtime(first).Equal(now) {
+        t.Errorf("%v skipped by resume file of another HEAD", first)
+    }
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Skipping runs at a HEAD already applied
+//------------------------------------------------------------
+
+// Tells if stamp file records HEAD, a missing one recording none.
+func stampCurrent(fpath, head string) (current bool, err error) {
+    data, err := os.ReadFile(fpath)
+    if os.IsNotExist(err) {
+        return false, nil
+    }
+    if err != nil {
+        return
+    }
+    return strings.TrimSpace(string(data)) == head, nil
+}
+
+// Records HEAD in stamp file after a complete run.
+func writeStamp(fpath, head string) error {
+    return os.WriteFile(fpath, []byte(head+"\n"), 0644)
+}
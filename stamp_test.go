@@ -0,0 +1,48 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A run at the HEAD the stamp file records does nothing, while --force
+// or a new commit runs again.
+func TestStampFile(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1"})
+    stamp := filepath.Join(t.TempDir(), "stamp")
+
+    if run := r.run("--stamp-file", stamp); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    data, err := os.ReadFile(stamp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if head := strings.TrimSpace(r.git("rev-parse", "HEAD")); strings.TrimSpace(string(data)) != head {
+        t.Errorf("stamp records %q, want HEAD %v", data, head)
+    }
+
+    // Same HEAD, file left as it is
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("a", now)
+    run := r.run("--stamp-file", stamp)
+    if run.Code != 0 || !strings.Contains(run.Stderr, "Already retimed at HEAD") {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if run.Stdout != "" || !r.mtime("a").Equal(now) {
+        t.Errorf("second run at same HEAD retimed a: %v", run.Stdout)
+    }
+
+    if run := r.run("--stamp-file", stamp, "--force"); run.Code != 0 || !r.mtime("a").Equal(mustTime(t, "2020-01-01T00:00:00Z")) {
+        t.Errorf("--force did not run: %v", run.Stderr)
+    }
+
+    r.commit("2020-02-01T00:00:00Z", map[string]string{"a": "2"})
+    if run := r.run("--stamp-file", stamp); run.Code != 0 || !r.mtime("a").Equal(mustTime(t, "2020-02-01T00:00:00Z")) {
+        t.Errorf("new HEAD did not run: %v", run.Stderr)
+    }
+}
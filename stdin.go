@@ -0,0 +1,82 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "strings"
+    "time"
+)
+
+//------------------------------------------------------------
+// Times computed by another tool, read from stdin
+//------------------------------------------------------------
+
+// Reads plan records "<path>\t<RFC3339-time>", one per line, or NUL
+// terminated if there's a NUL in the input which lets paths have
+// newlines. Blank records are ignored. Paths are cleaned and relative,
+// those pointing outside the tree are malformed. Each malformed record
+// is reported, and if any the plan is refused as a whole, the tool
+// upstream is likely broken. Modes are git modes of regular files, as
+// there's no HEAD to know them by.
+func readStdinPlan(r io.Reader) (plan []planEntry, modes map[string]string, err error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return
+    }
+    sep := "\n"
+    if bytes.IndexByte(data, 0) != -1 {
+        sep = "\x00"
+    }
+
+    modes = map[string]string{}
+    malformed := 0
+    for n, rec := range strings.Split(string(data), sep) {
+        rec = strings.TrimSuffix(rec, "\r")
+        if rec == "" {
+            continue
+        }
+        e, err := parsePlanRecord(rec)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "stdin:%d: %v\n", n+1, err)
+            malformed++
+            continue
+        }
+        plan = append(plan, e)
+        modes[e.Path] = "100644"
+    }
+    if malformed > 0 {
+        return nil, nil, fmt.Errorf("%d malformed records", malformed)
+    }
+    return
+}
+
+// Parses single plan record of --stdin-plan.
+func parsePlanRecord(rec string) (e planEntry, err error) {
+    f, raw, ok := strings.Cut(rec, "\t")
+    if !ok || f == "" {
+        return e, fmt.Errorf("expected <path><TAB><time>: %q", rec)
+    }
+    mtime, err := time.Parse(time.RFC3339, raw)
+    if err != nil {
+        return e, fmt.Errorf("time must be RFC3339: %q", raw)
+    }
+    name := path.Clean(strings.TrimPrefix(f, "./"))
+    if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+        return e, fmt.Errorf("path outside of the tree: %q", f)
+    }
+    return planEntry{Path: name, Mtime: mtime}, nil
+}
+
+// Applies plan read from stdin to the tree under root, or the current
+// directory, without git. Returns exit code.
+func runStdinPlan(opts Options) int {
+    plan, modes, err := readStdinPlan(os.Stdin)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading plan from stdin: %v\n", err)
+        return 1
+    }
+    return applyWithoutGit(plan, modes, nil, "planned", opts)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParsePlanRecord(t *testing.T) {
+    tests := []struct {
+        rec  string
+        path string
+        ok   bool
+    }{
+        {"a.txt\t2020-01-01T00:00:00Z", "a.txt", true},
+        {"./dir/a.txt\t2020-01-01T00:00:00+02:00", "dir/a.txt", true},
+        {"dir//x/../a\t2020-01-01T00:00:00Z", "dir/a", true},
+        {"with space\t2020-01-01T00:00:00Z", "with space", true},
+        {"a.txt 2020-01-01T00:00:00Z", "", false},
+        {"\t2020-01-01T00:00:00Z", "", false},
+        {"a.txt\t2020-01-01", "", false},
+        {"a.txt\t1577836800", "", false},
+        {"../a\t2020-01-01T00:00:00Z", "", false},
+        {"/etc/passwd\t2020-01-01T00:00:00Z", "", false},
+        {".\t2020-01-01T00:00:00Z", "", false},
+    }
+    for _, tt := range tests {
+        e, err := parsePlanRecord(tt.rec)
+        if tt.ok != (err == nil) || e.Path != tt.path {
+            t.Errorf("parsePlanRecord(%q) = %q, %v, want %q", tt.rec, e.Path, err, tt.path)
+        }
+    }
+}
+
+// Records are lines, or NUL terminated if there's a NUL, a malformed
+// one refuses the whole plan.
+func TestReadStdinPlan(t *testing.T) {
+    tests := []struct {
+        input string
+        paths []string
+        ok    bool
+    }{
+        {"a\t2020-01-01T00:00:00Z\nb\t2021-01-01T00:00:00Z\n", []string{"a", "b"}, true},
+        {"a\t2020-01-01T00:00:00Z\r\n\nb\t2021-01-01T00:00:00Z", []string{"a", "b"}, true},
+        {"new\nline\t2020-01-01T00:00:00Z\x00b\t2021-01-01T00:00:00Z\x00", []string{"new\nline", "b"}, true},
+        {"a\t2020-01-01T00:00:00Z\nbroken\n", nil, false},
+        {"", nil, true},
+    }
+    for _, tt := range tests {
+        plan, _, err := readStdinPlan(strings.NewReader(tt.input))
+        if tt.ok != (err == nil) || len(plan) != len(tt.paths) {
+            t.Errorf("readStdinPlan(%q) = %v, %v, want %v", tt.input, plan, err, tt.paths)
+            continue
+        }
+        for i, e := range plan {
+            if e.Path != tt.paths[i] {
+                t.Errorf("readStdinPlan(%q): path %q, want %q", tt.input, e.Path, tt.paths[i])
+            }
+        }
+    }
+}
+
+// Plan piped to gitime is applied without git, a malformed one not at
+// all.
+func TestStdinPlanRun(t *testing.T) {
+    dir := t.TempDir()
+    old := time.Unix(1500000000, 0)
+    for _, f := range []string{"a", "d/b"} {
+        fpath := filepath.Join(dir, filepath.FromSlash(f))
+        os.MkdirAll(filepath.Dir(fpath), 0755)
+        if err := os.WriteFile(fpath, nil, 0644); err != nil {
+            t.Fatal(err)
+        }
+        os.Chtimes(fpath, old, old)
+    }
+
+    run := runGitime(t, dir, "a\t2020-01-01T00:00:00Z\nd/b\tnot a time\n", "--stdin-plan")
+    if run.Code != 1 || !strings.Contains(run.Stderr, "stdin:2:") {
+        t.Errorf("malformed: exit status %d: %v", run.Code, run.Stderr)
+    }
+    if fi, _ := os.Stat(filepath.Join(dir, "a")); !fi.ModTime().Equal(old) {
+        t.Errorf("malformed plan applied")
+    }
+
+    run = runGitime(t, dir, "a\t2020-01-01T00:00:00Z\nd/b\t2021-01-01T00:00:00Z\n", "--stdin-plan")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    for f, want := range map[string]string{"a": "2020-01-01T00:00:00Z", "d/b": "2021-01-01T00:00:00Z"} {
+        fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f)))
+        if err != nil || !fi.ModTime().Equal(mustTime(t, want)) {
+            t.Errorf("%v: got %v, want %v", f, fi.ModTime(), want)
+        }
+    }
+}
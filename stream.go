@@ -0,0 +1,214 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "unsafe"
+)
+
+//------------------------------------------------------------
+// Applying while walking history, for trees too big to plan
+//------------------------------------------------------------
+
+// Parses size in bytes, with optional K, M or G suffix of powers of 1024.
+func parseBytes(s string) (n int64, err error) {
+    mult := int64(1)
+    switch {
+    case strings.HasSuffix(s, "K"):
+        mult = 1 << 10
+    case strings.HasSuffix(s, "M"):
+        mult = 1 << 20
+    case strings.HasSuffix(s, "G"):
+        mult = 1 << 30
+    }
+    if mult > 1 {
+        s = s[:len(s)-1]
+    }
+    n, err = strconv.ParseInt(s, 10, 64)
+    if err != nil || n < 0 {
+        return 0, errors.New("must be bytes, with optional K, M or G suffix")
+    }
+    return n * mult, nil
+}
+
+// Projects memory a plan of the tracked files would take, entries with
+// their path and commit hash. Maps and slices growing add more, this is
+// the least it takes.
+func planBytes(tracked map[string]string) (n int64) {
+    const hashLen = 40
+    for f := range tracked {
+        n += int64(unsafe.Sizeof(planEntry{})) + int64(len(f)) + hashLen
+    }
+    return
+}
+
+// Names option needing the whole plan before applying, so no streaming
+// apply, or empty if none does.
+func streamBlocker(opts Options) string {
+    blockers := []struct {
+        set  bool
+        name string
+    }{
+        {opts.Resolver != "log", "--resolver catfile"},
+        {opts.NullOnError, "--null-on-error"},
+        {opts.NotesRef != "", "--notes-ref"},
+        {opts.AlignOrder, "--align-to-commit-order"},
+        {opts.ByReleaseTag, "--by-release-tag"},
+        {opts.Index, "--index"},
+        {opts.TextOnly || opts.BinaryOnly, "--text-only or --binary-only"},
+        {opts.RespectIgnore, "--respect-gitignore"},
+        {opts.SkipAssumed || opts.SkipWorktree, "--skip-assume-unchanged or --skip-worktree"},
+        {opts.ByBlame, "--by-blame"},
+        {len(opts.LineRanges) > 0, "--line-range"},
+        {opts.OverrideFile != "", "--override-file"},
+        {opts.Granularity != "file", "--granularity"},
+        {opts.DebugSpread > 0, "--debug-spread"},
+        {opts.Birthtime != "", "--set-birthtime"},
+        {opts.SinceFile != "", "--since-file"},
+        {opts.NewOnly != "", "--touch-new-only"},
+        {opts.PrintEpoch || opts.PrintNewest || opts.PrintCommits, "printing options"},
+        {opts.Verify, "--verify"},
+        {opts.Audit, "--audit"},
+        {opts.EmitScript, "--emit-script"},
+        {opts.Dirs, "--dirs"},
+        {opts.DedupeLinks, "--dedupe-hardlinks"},
+        {opts.ResumeFile != "", "--resume-file"},
+        {opts.MarkerFile != "", "--write-marker"},
+        {opts.MinPlan > 0, "--apply-only-if-plan-size-at-least"},
+        {opts.Verbose, "--verbose"},
+        {opts.Confirm, "--confirm"},
+        {opts.UntilFirst, "--until-first-applied"},
+        {opts.Export != "", "--export"},
+    }
+    for _, b := range blockers {
+        if b.set {
+            return b.name
+        }
+    }
+    return ""
+}
+
+// Batches resolved ahead of applying, letting git run while files are
+// retimed without holding much of the plan.
+const streamQueue = 16
+
+// Files of one commit newest for them, or why resolving stopped.
+type streamBatch struct {
+    entries []planEntry
+    err     error
+}
+
+// Walks history newest first like buildPlan, applying files as they are
+// resolved instead of planning all first. Git log lists commits newest
+// first, so the first commit a file is seen in is its newest and its time
+// is final at once. Commits are read by one goroutine while files of
+// those before are retimed. Only the set of files done is kept, so each
+// is applied once. Exits like logWalk on failure.
+func streamRun(ctx context.Context, root string, tracked map[string]string, modes map[string]string, head string, opts Options, start time.Time) {
+    pathspecs := planPathspecs(opts)
+    hashes, err := getCommits(commitFilter(opts), pathspecs...)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error listing commits: %v\n", err)
+        exit(1)
+    }
+
+    var modeOnly map[string]map[string]bool
+    if opts.ContentOnly {
+        if modeOnly, err = gitModeOnlyChanges(commitFilter(opts), pathspecs); err != nil {
+            fmt.Fprintf(os.Stderr, "Error listing mode changes: %v\n", err)
+            exit(1)
+        }
+    }
+
+    stop := make(chan struct{})
+    defer close(stop)
+    batches := streamResolve(ctx, hashes, tracked, pathspecs, modeOnly, stop)
+
+    var stats runStats
+    var applyErr error
+    files := 0
+    for b := range batches {
+        if errors.Is(b.err, context.DeadlineExceeded) {
+            stats.StoppedEarly = true
+            fmt.Fprintf(os.Stderr, "WARNING out of --max-runtime %v, %d files applied so far\n", opts.MaxRuntime, files)
+            break
+        }
+        if b.err != nil {
+            fmt.Fprintf(os.Stderr, "Error reading commit: %v\n", b.err)
+            exit(1)
+        }
+
+        stats.Future += checkFuture(b.entries, start.Round(0), opts.NoFuture)
+        if opts.Round > 0 {
+            roundPlan(b.entries, opts.Round)
+        }
+        applyErr = stats.apply(root, b.entries, modes, opts)
+        files += len(b.entries)
+        if applyErr != nil {
+            break
+        }
+    }
+
+    if stats.Future > 0 {
+        fmt.Fprintf(os.Stderr, "WARNING %d files have commit time in the future\n", stats.Future)
+    }
+    finishRun(stats, files, applyErr, head, opts, start)
+}
+
+// Resolves tracked files commit by commit in a goroutine, sending those
+// seen first in each. Gives up once stop is closed.
+// Files deleted since aren't on disk, only tracked ones count.
+func streamResolve(ctx context.Context, hashes []string, tracked map[string]string, pathspecs []string, modeOnly map[string]map[string]bool, stop <-chan struct{}) <-chan streamBatch {
+    batches := make(chan streamBatch, streamQueue)
+    go func() {
+        defer close(batches)
+        send := func(b streamBatch) bool {
+            select {
+            case batches <- b:
+                return true
+            case <-stop:
+                return false
+            }
+        }
+
+        done := make(map[string]struct{}, len(tracked))
+        for _, hash := range hashes {
+            if len(done) == len(tracked) {
+                return
+            }
+            if err := ctx.Err(); err != nil {
+                send(streamBatch{err: err})
+                return
+            }
+            if hash == "" {
+                continue
+            }
+            mtime, fs, err := getCommitFiles(hash, pathspecs...)
+            if err != nil {
+                send(streamBatch{err: fmt.Errorf("%v: %v", hash, err)})
+                return
+            }
+
+            var batch []planEntry
+            for _, f := range fs {
+                if _, ok := tracked[f]; !ok || modeOnly[hash][f] {
+                    continue
+                }
+                if _, ok := done[f]; ok {
+                    continue
+                }
+                done[f] = struct{}{}
+                batch = append(batch, planEntry{Path: f, Mtime: mtime, Commit: hash})
+            }
+            if len(batch) > 0 && !send(streamBatch{entries: batch}) {
+                return
+            }
+        }
+    }()
+    return batches
+}
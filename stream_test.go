@@ -0,0 +1,74 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Streaming applies each tracked file once, to the same time a planned
+// run gives it, files deleted since left out.
+func TestStreamAppliesOnce(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1", "gone": "1"})
+    r.commit("2020-02-01T00:00:00Z", map[string]string{"a": "2", "b": "2"})
+    r.commit("2020-03-01T00:00:00Z", map[string]string{"a": "3", "d/e": "1"})
+    r.git("rm", "-q", "gone")
+    r.commit("2020-04-01T00:00:00Z", map[string]string{"b": "3"})
+    r.commit("2020-05-01T00:00:00Z", map[string]string{"a": "4"})
+
+    planned := r.run("--dry-run").times(t)
+    run := r.run("--stream", "--time-format", "rfc3339")
+    if run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+
+    seen := map[string]int{}
+    for _, line := range strings.Split(strings.TrimSpace(run.Stdout), "\n") {
+        _, f, _ := strings.Cut(line, " : ")
+        seen[f]++
+    }
+    for _, f := range []string{"a", "b", "c", "d/e"} {
+        if seen[f] != 1 {
+            t.Errorf("%v applied %d times, want once", f, seen[f])
+        }
+        if got := r.mtime(f); !got.Equal(planned[f]) {
+            t.Errorf("%v: got %v, want %v as planned", f, got, planned[f])
+        }
+    }
+    if len(seen) != 4 {
+        t.Errorf("applied %v, want tracked files only", seen)
+    }
+}
+
+// Chunks count files across calls of apply, as stream mode applies a
+// batch at a time, so pauses don't depend on the batches.
+func TestChunksAcrossBatches(t *testing.T) {
+    root := t.TempDir()
+    var files []string
+    var current, wanted []time.Time
+    for i := 0; i < 6; i++ {
+        files = append(files, fmt.Sprintf("f%d", i))
+        current = append(current, time.Unix(1500000000, 0))
+        wanted = append(wanted, time.Unix(1600000000, 0))
+    }
+    plan, modes := diskPlan(t, root, files, current, wanted)
+
+    // Six files in batches of one, chunks of two pause twice
+    pause := 100 * time.Millisecond
+    opts := Options{ChunkSize: 2, ChunkPause: pause, TimeFormat: "rfc3339"}
+    var stats runStats
+    started := time.Now()
+    for i := range plan {
+        if err := stats.apply(root, plan[i:i+1], modes, opts); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if elapsed := time.Since(started); elapsed < 2*pause {
+        t.Errorf("took %v, want two pauses of %v", elapsed, pause)
+    }
+    if stats.Applied != 6 {
+        t.Errorf("applied %d, want 6", stats.Applied)
+    }
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "time"
+)
+
+//------------------------------------------------------------
+// Machine readable report of a run
+//------------------------------------------------------------
+
+// Summary of a run, written by --summary-json.
+type runSummary struct {
+    Files          int            `json:"files"`
+    Applied        int            `json:"applied"`
+    Skipped        int            `json:"skipped"`
+    Errors         int            `json:"errors"`
+    ErrorClasses   map[string]int `json:"error_classes,omitempty"`
+    Future         int            `json:"future"`
+    Unresolved     int            `json:"unresolved"`
+    StoppedEarly   bool           `json:"stopped_early,omitempty"`
+    Oldest         *time.Time     `json:"oldest,omitempty"`
+    Newest         *time.Time     `json:"newest,omitempty"`
+    ElapsedSeconds float64        `json:"elapsed_seconds"`
+    GitVersion     string         `json:"git_version"`
+    Head           string         `json:"head"`
+}
+
+// Writes summary of a run to file.
+// Times are omitted when no file was applied.
+func writeSummary(fpath string, files int, stats runStats, elapsed time.Duration) (err error) {
+    sum := runSummary{
+        Files:          files,
+        Applied:        stats.Applied,
+        Skipped:        len(stats.Missing) + stats.TypeMismatch + stats.Unchanged + stats.Deduped + stats.Unmapped + stats.NotNewer + stats.Raced + stats.Gitlinks,
+        Errors:         stats.Errors,
+        Future:         stats.Future,
+        Unresolved:     stats.Unresolved,
+        StoppedEarly:   stats.StoppedEarly,
+        ElapsedSeconds: elapsed.Seconds(),
+    }
+    for class, files := range stats.Failed {
+        if sum.ErrorClasses == nil {
+            sum.ErrorClasses = map[string]int{}
+        }
+        sum.ErrorClasses[class] = len(files)
+    }
+    if stats.Applied > 0 {
+        sum.Oldest = &stats.Oldest
+        sum.Newest = &stats.Newest
+    }
+
+    if sum.GitVersion, err = gitVersion(); err != nil {
+        return
+    }
+    if sum.Head, err = gitHead(); err != nil {
+        return
+    }
+
+    data, err := json.MarshalIndent(sum, "", "    ")
+    if err != nil {
+        return
+    }
+    return os.WriteFile(fpath, append(data, '\n'), 0644)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "archive/tar"
+    "bufio"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "strings"
+)
+
+//------------------------------------------------------------
+// Times recorded in a tar archive
+//------------------------------------------------------------
+
+// Reads times of files and directories from headers of a tar archive,
+// gzip compressed or not. Paths are cleaned and relative, entries
+// pointing outside the tree are refused. Modes are git modes of regular
+// files, as the plan applied from the archive has no HEAD to know them
+// by. Links and special files are left out, Chtimes would change their
+// target.
+func readTar(fpath string) (plan []planEntry, modes map[string]string, dirs map[string]planEntry, err error) {
+    f, err := os.Open(fpath)
+    if err != nil {
+        return
+    }
+    defer f.Close()
+
+    var r io.Reader = bufio.NewReader(f)
+    if magic, _ := r.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+        if r, err = gzip.NewReader(r); err != nil {
+            return
+        }
+    }
+
+    modes = map[string]string{}
+    dirs = map[string]planEntry{}
+    tr := tar.NewReader(r)
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, nil, nil, err
+        }
+
+        name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+        if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+            return nil, nil, nil, errors.New("entry outside of the tree: " + hdr.Name)
+        }
+
+        e := planEntry{Path: name, Mtime: hdr.ModTime}
+        switch hdr.Typeflag {
+        case tar.TypeReg:
+            plan = append(plan, e)
+            modes[name] = "100644"
+        case tar.TypeDir:
+            dirs[name] = e
+        }
+    }
+    return
+}
+
+// Applies times of a tar archive to the tree under root, or the current
+// directory, without git. Files go first, then directories as changing
+// files doesn't change them. Returns exit code.
+func runTar(opts Options) int {
+    plan, modes, dirs, err := readTar(opts.FromTar)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error reading tar archive: %v\n", err)
+        return 1
+    }
+    return applyWithoutGit(plan, modes, dirs, "archived", opts)
+}
+
+// Applies plan not from git to the tree under root, or the current
+// directory, with directories after files. Missing files are reported
+// as what they are. Returns exit code.
+func applyWithoutGit(plan []planEntry, modes map[string]string, dirs map[string]planEntry, what string, opts Options) int {
+    root := "."
+    if opts.Root != "" {
+        root = opts.Root
+    }
+    stats, err := applyPlan(root, plan, modes, opts)
+    if err == nil {
+        retimeDirs(root, dirs, opts, &stats)
+    }
+
+    if hidden := stats.Errors - opts.ErrorLimit; opts.ErrorLimit > 0 && hidden > 0 {
+        fmt.Fprintf(os.Stderr, "... and %d more errors\n", hidden)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Stopped, %v\n", err)
+        return 1
+    }
+    if stats.Errors > 0 {
+        fmt.Fprintf(os.Stderr, "Error changing mtime of %d files\n", stats.Errors)
+        printErrorClasses(stats)
+        return 1
+    }
+    if opts.FailMissing && len(stats.Missing) > 0 {
+        fmt.Fprintf(os.Stderr, "Error %d %v files missing on disk\n", len(stats.Missing), what)
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Entry of a tar archive made for a test.
+type tarEntry struct {
+    Name     string
+    Typeflag byte
+    Mtime    string
+}
+
+// Makes tar archive of entries in memory, gzip compressed if asked.
+func makeTar(t *testing.T, entries []tarEntry, compress bool) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    var gz *gzip.Writer
+    if compress {
+        gz = gzip.NewWriter(&buf)
+        w = gz
+    }
+    tw := tar.NewWriter(w)
+    for _, e := range entries {
+        hdr := &tar.Header{Name: e.Name, Typeflag: e.Typeflag, Mode: 0644, ModTime: mustTime(t, e.Mtime)}
+        if e.Typeflag == tar.TypeSymlink {
+            hdr.Linkname = "a"
+        }
+        if err := tw.WriteHeader(hdr); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := tw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if gz != nil {
+        if err := gz.Close(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return buf.Bytes()
+}
+
+// Times of files and directories of an archive, plain or gzip, are
+// applied to the same paths here without git. Links keep their time.
+func TestFromTar(t *testing.T) {
+    entries := []tarEntry{
+        {"./src/", tar.TypeDir, "2019-01-01T00:00:00Z"},
+        {"./src/a", tar.TypeReg, "2020-01-01T00:00:00Z"},
+        {"b", tar.TypeReg, "2021-01-01T00:00:00Z"},
+        {"link", tar.TypeSymlink, "2022-01-01T00:00:00Z"},
+    }
+    for _, compress := range []bool{false, true} {
+        tree := &testRepo{t: t, Dir: t.TempDir()}
+        tree.write("src/a", "1")
+        tree.write("b", "1")
+        if err := os.Symlink("b", tree.path("link")); err != nil {
+            t.Fatal(err)
+        }
+        linkTime := tree.mtime("link")
+        archive := filepath.Join(t.TempDir(), "files.tar")
+        if err := os.WriteFile(archive, makeTar(t, entries, compress), 0644); err != nil {
+            t.Fatal(err)
+        }
+
+        if run := runGitime(t, tree.Dir, "", "--from-tar", archive); run.Code != 0 {
+            t.Fatalf("gzip %v: exit status %d: %v", compress, run.Code, run.Stderr)
+        }
+        for f, want := range map[string]string{"src": "2019-01-01T00:00:00Z", "src/a": "2020-01-01T00:00:00Z", "b": "2021-01-01T00:00:00Z"} {
+            if got := tree.mtime(f); !got.Equal(mustTime(t, want)) {
+                t.Errorf("gzip %v: %v got %v, want %v", compress, f, got, want)
+            }
+        }
+        if got := tree.mtime("link"); !got.Equal(linkTime) {
+            t.Errorf("gzip %v: link retimed to %v", compress, got)
+        }
+    }
+}
+
+// An entry pointing outside of the tree refuses the whole archive.
+func TestFromTarOutside(t *testing.T) {
+    tree := &testRepo{t: t, Dir: t.TempDir()}
+    tree.write("a", "1")
+    now := time.Now().Truncate(time.Second)
+    tree.setMtime("a", now)
+    archive := filepath.Join(t.TempDir(), "evil.tar")
+    entries := []tarEntry{{"a", tar.TypeReg, "2020-01-01T00:00:00Z"}, {"../escape", tar.TypeReg, "2020-01-01T00:00:00Z"}}
+    if err := os.WriteFile(archive, makeTar(t, entries, false), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    run := runGitime(t, tree.Dir, "", "--from-tar", archive)
+    if run.Code != 1 || !strings.Contains(run.Stderr, "entry outside of the tree") {
+        t.Errorf("exit status %d: %v", run.Code, run.Stderr)
+    }
+    if !tree.mtime("a").Equal(now) {
+        t.Errorf("a retimed from a refused archive")
+    }
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+)
+
+//------------------------------------------------------------
+// Checking options before doing anything
+//------------------------------------------------------------
+
+// Tells what is wrong with options, if anything. Values out of range
+// and options that conflict or would be silently ignored are caught
+// here, before git runs or any file changes.
+func (opts Options) validate() error {
+    if opts.TextOnly && opts.BinaryOnly {
+        return errors.New("Options --text-only and --binary-only are mutually exclusive")
+    }
+    if opts.Round < 0 {
+        return errors.New("Option --round must not be negative")
+    }
+    if opts.Format != "text" && opts.Format != "json" && opts.Format != "template" {
+        return fmt.Errorf("Unknown output format: %v", opts.Format)
+    }
+    if (opts.Format == "template") != (opts.Template != "") {
+        return errors.New("Options --format template and --template go together")
+    }
+    if opts.Format == "template" {
+        if _, err := parseEntryTemplate(opts.Template); err != nil {
+            return fmt.Errorf("Bad --template: %v", err)
+        }
+    }
+    if opts.TimeFormat == "" {
+        return errors.New("Option --time-format must not be empty")
+    }
+    if opts.Print0 && opts.Format != "text" {
+        return errors.New("Option --print0 can't be combined with --format")
+    }
+    if opts.Range != "" {
+        from, to, ok := strings.Cut(opts.Range, "..")
+        if !ok || from == "" || to == "" || strings.HasPrefix(to, ".") {
+            return fmt.Errorf("Option --range must be like a..b: %v", opts.Range)
+        }
+        if opts.FromCommit != "" {
+            return errors.New("Options --range and --from-commit are mutually exclusive")
+        }
+        if opts.ArchiveCompat {
+            return errors.New("Options --range and --archive-compat are mutually exclusive")
+        }
+        if opts.ByBlame {
+            return errors.New("Option --by-blame blames all of history, it can't be combined with --range")
+        }
+    }
+    if opts.Sentinel != "first-commit" && opts.Sentinel != "keep" {
+        return fmt.Errorf("Unknown sentinel: %v", opts.Sentinel)
+    }
+    if opts.OnMissingGit != "fail" && opts.OnMissingGit != "rebuild-index" {
+        return errors.New("Option --on-missing-git must be fail or rebuild-index")
+    }
+    if opts.MaxRuntime < 0 {
+        return errors.New("Option --max-runtime must not be negative")
+    }
+    if opts.Resolver != "log" && opts.Resolver != "catfile" {
+        return errors.New("Option --resolver must be log or catfile")
+    }
+    switch opts.EmptyDirTime {
+    case "skip", "now", "parent":
+    default:
+        return errors.New("Option --empty-dir-time must be skip, now or parent")
+    }
+    if opts.EmptyDirTime != "skip" && !opts.Dirs {
+        return errors.New("Option --empty-dir-time requires --dirs")
+    }
+    if opts.Diff && !opts.DryRun {
+        return errors.New("Option --diff requires --dry-run")
+    }
+    if opts.Diff && (opts.Print0 || opts.Format != "text") {
+        return errors.New("Option --diff prints text only, it can't be combined with --print0 or --format")
+    }
+    if opts.Verify && !opts.DryRun {
+        return errors.New("Option --verify requires --dry-run")
+    }
+    if opts.Verify && (opts.Diff || opts.EmitScript || opts.Dirs || opts.Print0 || opts.Format != "text") {
+        return errors.New("Option --verify prints a count only, it can't be combined with --diff, --emit-script, --dirs, --print0 or --format")
+    }
+    if opts.Audit && (opts.Verify || opts.Diff || opts.EmitScript || opts.Dirs || opts.Print0 || opts.Format == "template") {
+        return errors.New("Option --audit prints findings only, it can't be combined with --verify, --diff, --emit-script, --dirs, --print0 or --format template")
+    }
+    if opts.EmitScript && (opts.Diff || opts.Print0 || opts.Format != "text") {
+        return errors.New("Option --emit-script prints a shell script, it can't be combined with --diff, --print0 or --format")
+    }
+    if opts.EmitScript && (opts.PrintEpoch || opts.PrintFiles || opts.PrintNewest || opts.PrintCommits) {
+        return errors.New("Option --emit-script can't be combined with --print-epoch, --print-files, --print-newest-per-dir or --print-commits")
+    }
+    if opts.Granularity != "file" && opts.Granularity != "component" {
+        return errors.New("Option --granularity must be file or component")
+    }
+    if opts.OnConflict != "last-wins" && opts.OnConflict != "overrides-win" {
+        return errors.New("Option --on-conflict must be last-wins or overrides-win")
+    }
+    if opts.Birthtime != "" && opts.Birthtime != "first" && opts.Birthtime != "last" {
+        return errors.New("Option --set-birthtime must be first or last")
+    }
+    if opts.AssumePresent && (opts.SkipUnchanged || opts.NewerOnly || opts.DedupeLinks || opts.Diff || opts.Verify || opts.Audit || opts.Sentinel == "keep") {
+        return errors.New("Option --assume-present skips the stat of files, it can't be combined with --skip-unchanged, --minimal, --newer-only, --dedupe-hardlinks, --diff, --verify, --audit or --sentinel keep")
+    }
+    if opts.NoDereference && opts.Birthtime != "" {
+        return errors.New("Option --set-birthtime follows symlinks, it can't be combined with --no-dereference or --minimal")
+    }
+    if opts.RepoJobs < 0 {
+        return errors.New("Option --repo-jobs must not be negative")
+    }
+    if opts.StatJobs < 0 {
+        return errors.New("Option --stat-jobs must not be negative")
+    }
+    if opts.DebugSpread < 0 {
+        return errors.New("Option --debug-spread must not be negative")
+    }
+    if opts.ErrorLimit < 0 {
+        return errors.New("Option --error-limit must not be negative")
+    }
+    if opts.ChunkSize < 0 || opts.ChunkPause < 0 {
+        return errors.New("Options --chunk-size and --chunk-pause must not be negative")
+    }
+
+    if opts.IndexTime != "" {
+        if !opts.Index {
+            return errors.New("Option --index-time requires --index")
+        }
+        if _, err := time.Parse(time.RFC3339, opts.IndexTime); err != nil {
+            return fmt.Errorf("Option --index-time must be an RFC3339 time: %v", opts.IndexTime)
+        }
+    }
+    if opts.Index && (opts.FromCommit != "" || opts.ArchiveCompat || opts.Range != "") {
+        return errors.New("Option --index stages over HEAD, it can't be combined with --from-commit, --archive-compat or --range")
+    }
+
+    if opts.Approx && (opts.FromCommit != "" || opts.ArchiveCompat || opts.Range != "") {
+        return errors.New("Option --approx can't be combined with --from-commit, --archive-compat or --range")
+    }
+
+    // A single commit needs no history walk
+    if opts.FromCommit != "" || opts.ArchiveCompat || opts.Approx {
+        switch {
+        case opts.MaxRuntime > 0:
+            return errors.New("Option --max-runtime limits walking history, which --from-commit, --archive-compat and --approx don't do")
+        case opts.NullOnError:
+            return errors.New("Option --null-on-error has no effect with --from-commit, --archive-compat or --approx, all files get the commit time")
+        case opts.Author != "":
+            return errors.New("Option --author selects commits of history, which --from-commit, --archive-compat and --approx don't walk")
+        case opts.WriteGraph:
+            return errors.New("Option --write-commit-graph speeds up walking history, which --from-commit, --archive-compat and --approx don't do")
+        case opts.ContentOnly:
+            return errors.New("Option --content-only filters commits of history, which --from-commit, --archive-compat and --approx don't walk")
+        case opts.ByBlame:
+            return errors.New("Option --by-blame takes times of history, which --from-commit, --archive-compat and --approx don't use")
+        }
+    }
+
+    // Only printing, nothing is applied
+    reports := 0
+    for _, set := range []bool{opts.PrintEpoch, opts.PrintFiles, opts.PrintNewest, opts.PrintCommits} {
+        if set {
+            reports++
+        }
+    }
+    if reports > 1 {
+        return errors.New("Options --print-epoch, --print-files, --print-newest-per-dir and --print-commits are mutually exclusive")
+    }
+    if reports > 0 && (opts.DryRun || opts.Dirs) {
+        return errors.New("Options --print-epoch, --print-files, --print-newest-per-dir and --print-commits change nothing, --dry-run and --dirs don't apply")
+    }
+    if opts.PrintCommits && (opts.Print0 || opts.Format == "template") {
+        return errors.New("Option --print-commits prints text or json only, it can't be combined with --print0 or --format template")
+    }
+    if opts.Audit && (reports > 0 || opts.ResumeFile != "" || opts.StampFile != "" || opts.MarkerFile != "" || opts.FromTar != "" || opts.StdinPlan) {
+        return errors.New("Option --audit changes nothing, it can't be combined with printing options, --resume-file, --stamp-file, --write-marker, --from-tar or --stdin-plan")
+    }
+    if opts.ResumeFile != "" && (reports > 0 || opts.DryRun || opts.EmitScript) {
+        return errors.New("Option --resume-file records applied files, it can't be combined with --dry-run, --emit-script or printing options")
+    }
+    if opts.StampFile != "" && (reports > 0 || opts.DryRun || opts.EmitScript) {
+        return errors.New("Option --stamp-file records applied runs, it can't be combined with --dry-run, --emit-script or printing options")
+    }
+    if opts.MarkerFile != "" && (reports > 0 || opts.Verify || opts.EmitScript) {
+        return errors.New("Option --write-marker touches a file here, it can't be combined with --verify, --emit-script or printing options")
+    }
+    if opts.OnMissingGit == "rebuild-index" && (reports > 0 || opts.DryRun || opts.EmitScript || opts.Audit) {
+        return errors.New("Option --on-missing-git rebuild-index changes the git index, it can't be combined with --dry-run, --emit-script, --audit or printing options")
+    }
+    if opts.Yes && !opts.Confirm {
+        return errors.New("Option --yes requires --confirm")
+    }
+    if opts.Confirm && (reports > 0 || opts.DryRun || opts.EmitScript || opts.Audit || opts.Stream) {
+        return errors.New("Option --confirm asks before applying a plan, it can't be combined with --dry-run, --emit-script, --audit, --stream or printing options")
+    }
+    if opts.UntilFirst && (reports > 0 || opts.DryRun || opts.EmitScript || opts.Audit || opts.Dirs || opts.ResumeFile != "" || opts.StampFile != "" || opts.MarkerFile != "") {
+        return errors.New("Option --until-first-applied retimes one file, it can't be combined with --dry-run, --emit-script, --audit, --dirs, --resume-file, --stamp-file, --write-marker or printing options")
+    }
+    if opts.Export != "" {
+        switch {
+        case opts.Root != "":
+            return errors.New("Options --export and --root are mutually exclusive")
+        case opts.FromCommit != "" && opts.FromCommit != "HEAD":
+            return errors.New("Option --export copies files of HEAD, it can't be combined with --from-commit of another commit")
+        case opts.FromTar != "" || opts.StdinPlan || opts.Index:
+            return errors.New("Option --export can't be combined with --from-tar, --stdin-plan or --index")
+        case reports > 0 || opts.DryRun || opts.EmitScript || opts.Audit || opts.Verify || opts.ResumeFile != "":
+            return errors.New("Option --export writes a new tree, it can't be combined with --dry-run, --emit-script, --audit, --verify, --resume-file or printing options")
+        }
+    }
+    if opts.MinPlan < 0 {
+        return errors.New("Option --apply-only-if-plan-size-at-least must not be negative")
+    }
+    if opts.Force && opts.StampFile == "" && opts.MinPlan == 0 {
+        return errors.New("Option --force requires --stamp-file or --apply-only-if-plan-size-at-least")
+    }
+    // Times from an archive or another tool, applied without git
+    var source string
+    switch {
+    case opts.FromTar != "" && opts.StdinPlan:
+        return errors.New("Options --from-tar and --stdin-plan are mutually exclusive")
+    case opts.FromTar != "":
+        source = "--from-tar"
+    case opts.StdinPlan:
+        source = "--stdin-plan"
+    }
+    if source != "" {
+        switch {
+        case opts.FromCommit != "" || opts.ArchiveCompat || opts.Range != "" || opts.Approx:
+            return fmt.Errorf("Option %v takes times from its input, it can't be combined with --from-commit, --archive-compat, --range or --approx", source)
+        case reports > 0 || opts.Verify || opts.EmitScript || opts.Dirs:
+            return fmt.Errorf("Option %v only applies or dry runs, it can't be combined with printing options, --verify, --emit-script or --dirs", source)
+        case opts.ResumeFile != "" || opts.StampFile != "" || opts.Index:
+            return fmt.Errorf("Option %v works without git, it can't be combined with --resume-file, --stamp-file or --index", source)
+        case opts.MarkerFile != "" || opts.Confirm || opts.UntilFirst:
+            return fmt.Errorf("Option %v can't be combined with --write-marker, --confirm or --until-first-applied", source)
+        }
+    }
+    if opts.Stream {
+        if opts.FromCommit != "" || opts.ArchiveCompat || opts.Approx || opts.Range != "" || source != "" {
+            return errors.New("Option --stream applies while walking history, it can't be combined with --from-commit, --archive-compat, --approx, --range, --from-tar or --stdin-plan")
+        }
+        if blocker := streamBlocker(opts); blocker != "" {
+            return fmt.Errorf("Option --stream applies files before all are resolved, it can't be combined with %v", blocker)
+        }
+    }
+    if opts.Depth < 1 {
+        return errors.New("Option --depth must be at least 1")
+    }
+
+    if opts.Root != "" {
+        fi, err := os.Stat(opts.Root)
+        if err != nil || !fi.IsDir() {
+            return fmt.Errorf("Root directory doesn't exist: %v", opts.Root)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+// Options as flags default them.
+func defaultOptions() Options {
+    return Options{
+        TimeFormat:   "rfc3339",
+        Format:       "text",
+        Sentinel:     "first-commit",
+        OnMissingGit: "fail",
+        Resolver:     "log",
+        EmptyDirTime: "skip",
+        Granularity:  "file",
+        OnConflict:   "last-wins",
+        Depth:        1,
+    }
+}
+
+// Options that conflict are rejected with an error naming them, those
+// that go together are not.
+func TestValidate(t *testing.T) {
+    if err := defaultOptions().validate(); err != nil {
+        t.Fatalf("default options rejected: %v", err)
+    }
+
+    cases := []struct {
+        name string
+        set  func(*Options)
+        want string // Part of error, empty for none
+    }{
+        {"text and binary only", func(o *Options) { o.TextOnly, o.BinaryOnly = true, true }, "--text-only and --binary-only"},
+        {"assume present newer only", func(o *Options) { o.AssumePresent, o.NewerOnly = true, true }, "--assume-present"},
+        {"assume present minimal", func(o *Options) { o.AssumePresent, o.SkipUnchanged = true, true }, "--assume-present"},
+        {"dry run force", func(o *Options) { o.DryRun, o.Force = true, true }, "--force requires"},
+        {"force stamp file", func(o *Options) { o.Force, o.StampFile = true, "stamp" }, ""},
+        {"stamp file dry run", func(o *Options) { o.StampFile, o.DryRun = "stamp", true }, "--stamp-file"},
+        {"diff without dry run", func(o *Options) { o.Diff = true }, "--diff requires --dry-run"},
+        {"diff dry run", func(o *Options) { o.Diff, o.DryRun = true, true }, ""},
+        {"diff json", func(o *Options) { o.Diff, o.DryRun, o.Format = true, true, "json" }, "--diff prints text"},
+        {"print0 json", func(o *Options) { o.Print0, o.Format = true, "json" }, "--print0"},
+        {"template without text", func(o *Options) { o.Format = "template" }, "--format template and --template"},
+        {"range and from commit", func(o *Options) { o.Range, o.FromCommit = "a..b", "c" }, "--range and --from-commit"},
+        {"bad range", func(o *Options) { o.Range = "a..." }, "must be like a..b"},
+        {"two reports", func(o *Options) { o.PrintFiles, o.PrintEpoch = true, true }, "mutually exclusive"},
+        {"report dry run", func(o *Options) { o.PrintFiles, o.DryRun = true, true }, "change nothing"},
+        {"yes without confirm", func(o *Options) { o.Yes = true }, "--yes requires --confirm"},
+        {"confirm stream", func(o *Options) { o.Confirm, o.Stream = true, true }, "--confirm"},
+        {"approx content only", func(o *Options) { o.Approx, o.ContentOnly = true, true }, "--content-only"},
+        {"from tar stdin plan", func(o *Options) { o.FromTar, o.StdinPlan = "a.tar", true }, "--from-tar and --stdin-plan"},
+        {"from tar index", func(o *Options) { o.FromTar, o.Index = "a.tar", true }, "works without git"},
+        {"stream from commit", func(o *Options) { o.Stream, o.FromCommit = true, "HEAD" }, "--stream"},
+        {"export root", func(o *Options) { o.Export, o.Root = "out", "." }, "--export and --root"},
+        {"audit verify", func(o *Options) { o.Audit, o.Verify, o.DryRun = true, true, true }, "--audit"},
+        {"negative round", func(o *Options) { o.Round = -1 }, "--round"},
+        {"zero depth", func(o *Options) { o.Depth = 0 }, "--depth"},
+        {"unknown resolver", func(o *Options) { o.Resolver = "blame" }, "--resolver"},
+    }
+    for _, c := range cases {
+        opts := defaultOptions()
+        c.set(&opts)
+        err := opts.validate()
+        switch {
+        case c.want == "" && err != nil:
+            t.Errorf("%v: rejected: %v", c.name, err)
+        case c.want != "" && err == nil:
+            t.Errorf("%v: accepted, want error with %q", c.name, c.want)
+        case c.want != "" && !strings.Contains(err.Error(), c.want):
+            t.Errorf("%v: got %q, want error with %q", c.name, err, c.want)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+)
+
+//------------------------------------------------------------
+// Checking times on disk against the plan
+//------------------------------------------------------------
+
+// Counts files whose time on disk differs from the plan, printing
+// a one line result and, if verbose, each such file as --diff does.
+// Files applyPlan would skip aren't counted, nor are unresolved ones
+// kept at their time.
+func verifyPlan(root string, plan []planEntry, modes map[string]string, opts Options) (wrong int) {
+    fpaths := localPaths(root, plan, opts.PathMapper)
+    states := statPlan(fpaths, opts.StatJobs, opts.NoDereference)
+
+    checked := 0
+    for i, e := range plan {
+        st := states[i]
+        if fpaths[i] == "" || st.Err != nil || !sameKind(modes[e.Path], st.Info.Mode()) {
+            continue
+        }
+        if modes[e.Path] == "160000" && !opts.Gitlinks {
+            continue
+        }
+        if e.Unresolved && opts.Sentinel == "keep" {
+            continue
+        }
+
+        checked++
+        if st.Mtime.Equal(e.Mtime) {
+            continue
+        }
+        wrong++
+        if opts.Verbose {
+            printDiff(e, st, opts.TimeFormat)
+        }
+    }
+    fmt.Printf("gitime: %d of %d files have incorrect mtime\n", wrong, checked)
+    return
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// Verify prints a count of files with wrong time and fails if any, the
+// files listed only with --verbose, and changes nothing.
+func TestVerify(t *testing.T) {
+    r := newTestRepo(t)
+    r.commit("2020-01-01T00:00:00Z", map[string]string{"a": "1", "b": "1", "c": "1"})
+    if run := r.run(); run.Code != 0 {
+        t.Fatalf("exit status %d: %v", run.Code, run.Stderr)
+    }
+
+    run := r.run("--dry-run", "--verify")
+    if run.Code != 0 || run.Stdout != "gitime: 0 of 3 files have incorrect mtime\n" {
+        t.Errorf("exit status %d: %q", run.Code, run.Stdout)
+    }
+
+    now := time.Now().Truncate(time.Second)
+    r.setMtime("b", now)
+    run = r.run("--dry-run", "--verify")
+    if run.Code != 1 || run.Stdout != "gitime: 1 of 3 files have incorrect mtime\n" {
+        t.Errorf("exit status %d: %q", run.Code, run.Stdout)
+    }
+
+    run = r.run("--dry-run", "--verify", "--verbose")
+    lines := strings.Split(strings.TrimSuffix(run.Stdout, "\n"), "\n")
+    if run.Code != 1 || len(lines) != 2 || !strings.HasPrefix(lines[0], "b: ") || lines[1] != "gitime: 1 of 3 files have incorrect mtime" {
+        t.Errorf("exit status %d: %q", run.Code, run.Stdout)
+    }
+    if !r.mtime("b").Equal(now) {
+        t.Errorf("verify retimed b")
+    }
+}